@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// apiKeys holds the keys accepted by apiKeyMiddleware, it is set up in main and stays empty
+// when API_KEY is not set, which leaves the endpoints open
+var apiKeys []string
+
+// loadAPIKeys returns the comma-separated keys of the API_KEY env var
+func loadAPIKeys() []string {
+	var keys []string
+	for _, key := range strings.Split(os.Getenv("API_KEY"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// apiKeyMiddleware rejects requests without one of the configured API keys, sent either as
+// "Authorization: Bearer <key>" or in the X-API-Key header
+func apiKeyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(apiKeys) > 0 && !hasValidAPIKey(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gitdump"`)
+			writeJSONError(w, http.StatusUnauthorized, "Missing or invalid API key")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// hasValidAPIKey reports whether a request carries one of the configured API keys. Every
+// key is compared in constant time, so the comparison does not reveal which key came close.
+func hasValidAPIKey(r *http.Request) bool {
+	provided := r.Header.Get("X-API-Key")
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		provided = strings.TrimSpace(token)
+	}
+	if provided == "" {
+		return false
+	}
+
+	valid := 0
+	for _, key := range apiKeys {
+		valid |= subtle.ConstantTimeCompare([]byte(provided), []byte(key))
+	}
+	return valid == 1
+}
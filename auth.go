@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthRequest describes per-request credentials for cloning a private repository
+type AuthRequest struct {
+	Type       string `json:"type"`                   // "token" or "ssh"
+	Token      string `json:"token,omitempty"`        // Access or deploy token for type "token"
+	Username   string `json:"username,omitempty"`     // Username sent with the token, defaults depend on the host
+	SSHKey     string `json:"ssh_key,omitempty"`      // Private key contents for type "ssh"
+	SSHKeyPath string `json:"ssh_key_path,omitempty"` // Private key file inside SSH_KEY_DIR for type "ssh"
+}
+
+// gitAuth holds the environment that makes git use a request's credentials
+type gitAuth struct {
+	env     []string    // Extra environment variables for git
+	config  [][2]string // Git configuration key/value pairs passed through the environment
+	secrets []string    // Values that must never appear in logs or error messages
+	tempDir string      // Directory with temporary key material, removed by cleanup
+}
+
+// urlCredentialsPattern matches credentials embedded in URLs in git output
+var urlCredentialsPattern = regexp.MustCompile(`(https?://)[^/@\s]+@`)
+
+// validateAuth checks that the credentials in a request match the repository URL scheme
+func validateAuth(req *RepoRequest) error {
+	if req.Auth == nil {
+		return nil
+	}
+
+	parsedURL, err := parseRepoURL(req.RepoURL)
+	if err != nil {
+		return err
+	}
+
+	switch req.Auth.Type {
+	case "token":
+		if req.Auth.Token == "" {
+			return fmt.Errorf("auth token is required")
+		}
+		if parsedURL.Scheme != "https" && parsedURL.Scheme != "http" {
+			return fmt.Errorf("token auth requires an http or https repository URL")
+		}
+
+	case "ssh":
+		if (req.Auth.SSHKey == "") == (req.Auth.SSHKeyPath == "") {
+			return fmt.Errorf("exactly one of ssh_key or ssh_key_path is required")
+		}
+		if parsedURL.Scheme != "ssh" {
+			return fmt.Errorf("ssh auth requires an ssh repository URL")
+		}
+		if req.Auth.SSHKeyPath != "" {
+			if _, err := resolveSSHKeyPath(req.Auth.SSHKeyPath); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("unsupported auth type %q, expected token or ssh", req.Auth.Type)
+	}
+
+	return nil
+}
+
+// resolveSSHKeyPath returns the absolute path of a key file, which must be inside SSH_KEY_DIR
+// so callers cannot make the service use arbitrary files on its host as keys
+func resolveSSHKeyPath(keyPath string) (string, error) {
+	keyDir := os.Getenv("SSH_KEY_DIR")
+	if keyDir == "" {
+		return "", fmt.Errorf("ssh_key_path requires SSH_KEY_DIR to be configured")
+	}
+
+	keyDir, err := filepath.Abs(keyDir)
+	if err != nil {
+		return "", err
+	}
+
+	resolved := filepath.Join(keyDir, keyPath)
+	if !strings.HasPrefix(resolved, keyDir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("ssh_key_path must be inside SSH_KEY_DIR")
+	}
+
+	return resolved, nil
+}
+
+// prepareAuth builds the git environment for a request's credentials. Per-request auth takes
+// precedence over the GITHUB_TOKEN used for is_private requests. The caller must call
+// cleanup on the result, which also removes any temporary key files.
+func prepareAuth(req *RepoRequest) (*gitAuth, error) {
+	auth := &gitAuth{}
+
+	parsedURL, err := parseRepoURL(req.RepoURL)
+	if err != nil {
+		return auth, err
+	}
+
+	authReq := req.Auth
+	if authReq == nil && req.IsPrivate && (parsedURL.Scheme == "https" || parsedURL.Scheme == "http") {
+		githubToken := os.Getenv("GITHUB_TOKEN")
+		if githubToken == "" {
+			return auth, fmt.Errorf("GITHUB_TOKEN environment variable not set for private repository")
+		}
+		authReq = &AuthRequest{Type: "token", Token: githubToken}
+	}
+
+	if authReq == nil {
+		return auth, nil
+	}
+
+	switch authReq.Type {
+	case "token":
+		username := authReq.Username
+		if username == "" {
+			username = defaultTokenUsername(parsedURL.Hostname())
+		}
+
+		// Send the token as a header scoped to the repository host instead of embedding it in the
+		// URL, so it does not show up in the process list or git output and is reused for
+		// submodules hosted on the same server
+		credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + authReq.Token))
+		auth.secrets = append(auth.secrets, authReq.Token, credentials)
+		auth.config = append(auth.config, [2]string{
+			fmt.Sprintf("http.%s://%s/.extraHeader", parsedURL.Scheme, parsedURL.Host),
+			"Authorization: Basic " + credentials,
+		})
+
+	case "ssh":
+		keyPath := ""
+		if authReq.SSHKeyPath != "" {
+			if keyPath, err = resolveSSHKeyPath(authReq.SSHKeyPath); err != nil {
+				return auth, err
+			}
+		}
+
+		if auth.tempDir, err = os.MkdirTemp("", "gitdump-ssh-"); err != nil {
+			return auth, fmt.Errorf("failed to create temporary key directory: %v", err)
+		}
+
+		if authReq.SSHKey != "" {
+			auth.secrets = append(auth.secrets, authReq.SSHKey)
+			keyPath = filepath.Join(auth.tempDir, "id_key")
+
+			key := authReq.SSHKey
+			if !strings.HasSuffix(key, "\n") {
+				key += "\n"
+			}
+			if err := os.WriteFile(keyPath, []byte(key), 0600); err != nil {
+				auth.cleanup()
+				return auth, fmt.Errorf("failed to write temporary key file: %v", err)
+			}
+		}
+
+		// Use only the given key and refuse hosts whose key is not known, so the key is never
+		// offered to a server impersonating the host
+		command := fmt.Sprintf("ssh -i %s -o IdentitiesOnly=yes -o BatchMode=yes -o StrictHostKeyChecking=yes", shellQuote(keyPath))
+		if knownHosts := os.Getenv("SSH_KNOWN_HOSTS"); knownHosts != "" {
+			if _, err := os.Stat(knownHosts); err != nil {
+				auth.cleanup()
+				return auth, fmt.Errorf("SSH_KNOWN_HOSTS is not readable: %v", err)
+			}
+			command += " -o UserKnownHostsFile=" + shellQuote(knownHosts)
+		}
+		auth.env = append(auth.env, "GIT_SSH_COMMAND="+command)
+	}
+
+	return auth, nil
+}
+
+// defaultTokenUsername returns the username that the hosting provider expects with an access token
+func defaultTokenUsername(host string) string {
+	switch {
+	case strings.Contains(host, "gitlab"):
+		return "oauth2"
+	case strings.Contains(host, "bitbucket"):
+		return "x-token-auth"
+	default:
+		return "x-access-token"
+	}
+}
+
+// environ returns the environment variables that apply the credentials to a git command
+func (a *gitAuth) environ() []string {
+	if a == nil {
+		return nil
+	}
+
+	env := append([]string{}, a.env...)
+	for i, entry := range a.config {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, entry[0]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, entry[1]))
+	}
+	if len(a.config) > 0 {
+		env = append(env, "GIT_CONFIG_COUNT="+strconv.Itoa(len(a.config)))
+	}
+	return env
+}
+
+// cleanup removes temporary key material, it is safe to call on a nil or empty auth
+func (a *gitAuth) cleanup() {
+	if a == nil || a.tempDir == "" {
+		return
+	}
+	if err := os.RemoveAll(a.tempDir); err != nil {
+		log.Printf("Failed to remove temporary key directory %s: %v", a.tempDir, err)
+	}
+	a.tempDir = ""
+}
+
+// scrub removes credentials from text that may be logged or returned to the client
+func (a *gitAuth) scrub(text string) string {
+	if a != nil {
+		for _, secret := range a.secrets {
+			if secret != "" {
+				text = strings.ReplaceAll(text, secret, "***")
+			}
+		}
+	}
+	return urlCredentialsPattern.ReplaceAllString(text, "${1}***@")
+}
+
+// gitWaitDelay is how long a killed git command may take to release its output
+const gitWaitDelay = 5 * time.Second
+
+// runGit runs a git command with the credentials of a request and returns its combined
+// output with any credentials scrubbed
+func runGit(ctx context.Context, auth *gitAuth, args ...string) (string, error) {
+	// git and the helpers it spawned are killed once the context is done. Stop waiting for
+	// their output shortly after, in case one of them keeps it open.
+	cmd := exec.CommandContext(ctx, "git", args...)
+	killProcessGroupOnCancel(cmd)
+	cmd.WaitDelay = gitWaitDelay
+
+	// Never wait for credentials on a terminal that does not exist
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Env = append(cmd.Env, auth.environ()...)
+
+	output, err := cmd.CombinedOutput()
+	return auth.scrub(string(output)), err
+}
+
+// shellQuote quotes a value for use in GIT_SSH_COMMAND, which git runs through the shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// parseRepoURL parses a repository URL, rewriting scp-style SSH URLs such as
+// git@github.com:org/repo.git so they can be handled like any other URL
+func parseRepoURL(repoURL string) (*url.URL, error) {
+	rawURL := repoURL
+	if !strings.Contains(repoURL, "://") {
+		if m := scpURLPattern.FindStringSubmatch(repoURL); m != nil {
+			rawURL = "ssh://" + m[1] + "@" + m[2] + "/" + m[3]
+		}
+	}
+	return url.Parse(rawURL)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	maxBatchSize            = 50 // Maximum number of repositories in a single batch
+	defaultBatchConcurrency = 4  // Repositories analyzed at once when BATCH_CONCURRENCY is not set
+)
+
+// BatchResult is the outcome of a single repository of a batch. Failed repositories
+// carry the error and its HTTP status instead of a result.
+type BatchResult struct {
+	RepoURL string        `json:"repo_url"`
+	Status  int           `json:"status"`
+	Error   string        `json:"error,omitempty"`
+	Result  *RepoResponse `json:"result,omitempty"`
+}
+
+// batchConcurrency returns the number of repositories analyzed at once from the
+// BATCH_CONCURRENCY env var
+func batchConcurrency() int {
+	if value := os.Getenv("BATCH_CONCURRENCY"); value != "" {
+		if concurrency, err := strconv.Atoi(value); err == nil && concurrency > 0 {
+			return concurrency
+		}
+		log.Printf("Warning: Invalid BATCH_CONCURRENCY %q, using default of %d", value, defaultBatchConcurrency)
+	}
+	return defaultBatchConcurrency
+}
+
+// handleAnalyzeBatch analyzes several repositories in one call and returns a combined
+// markdown document or a JSON array. A failing repository does not fail the batch.
+func handleAnalyzeBatch(w http.ResponseWriter, r *http.Request) {
+	// Parse request body
+	var reqs []RepoRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		log.Printf("Error parsing request body: %v", err)
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	if len(reqs) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "At least one repository is required")
+		return
+	}
+	if len(reqs) > maxBatchSize {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("A batch may contain at most %d repositories", maxBatchSize))
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "markdown" // Default to markdown
+	}
+	countTokens := r.URL.Query().Get("count_tokens") == "true"
+	trusted := isTrustedCaller(r)
+
+	log.Printf("Analyzing batch of %d repositories", len(reqs))
+	results := make([]BatchResult, len(reqs))
+	slots := make(chan struct{}, batchConcurrency())
+
+	var wg sync.WaitGroup
+	for i := range reqs {
+		req := &reqs[i]
+		if countTokens {
+			req.CountTokens = true
+		}
+		req.trusted = trusted
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			results[i] = analyzeBatchRepo(r.Context(), req)
+		}(i)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	} else {
+		w.Header().Set("Content-Type", "text/markdown")
+		w.Header().Set("Content-Disposition", "attachment; filename=batch-analysis.md")
+		w.Write([]byte(generateBatchMarkdown(results)))
+	}
+	log.Printf("Batch response sent successfully, %d of %d repositories failed", failed, len(results))
+}
+
+// analyzeBatchRepo validates and analyzes a single repository of a batch within the time
+// limit. Every repository takes a slot of the limiter of its own, so a batch cannot bypass
+// the limit, and is cloned into its own temporary directory, which runAnalysis removes.
+func analyzeBatchRepo(ctx context.Context, req *RepoRequest) BatchResult {
+	result := BatchResult{RepoURL: req.RepoURL}
+
+	err := validateRequest(req)
+	if err == nil {
+		err = analyzeBatchRepoWithSlot(ctx, req, &result)
+	}
+	if err != nil {
+		log.Printf("Batch analysis of %s failed: %v", req.RepoURL, err)
+		metrics.recordAnalysis("batch", nil, err)
+		result.Status = errorStatus(err)
+		result.Error = err.Error()
+		return result
+	}
+
+	metrics.recordAnalysis("batch", result.Result.Stats, nil)
+	result.Status = http.StatusOK
+	return result
+}
+
+// analyzeBatchRepoWithSlot waits for a slot of the limiter and analyzes a validated
+// repository of a batch into result
+func analyzeBatchRepoWithSlot(ctx context.Context, req *RepoRequest, result *BatchResult) error {
+	release, err := limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// The time limit applies to each repository, not to the whole batch, and starts once it runs
+	ctx, cancel := context.WithTimeout(ctx, analyzeTimeout())
+	defer cancel()
+
+	result.Result, _, err = runAnalysis(ctx, req)
+	return err
+}
+
+// generateBatchMarkdown combines the documents of a batch, with each repository as a
+// top-level section in the order of the request
+func generateBatchMarkdown(results []BatchResult) string {
+	var builder strings.Builder
+
+	for _, result := range results {
+		heading := "# Repository Analysis: " + result.RepoURL + "\n\n"
+		if result.Error != "" {
+			builder.WriteString(heading)
+			builder.WriteString(fmt.Sprintf("Analysis failed (%d): %s\n\n", result.Status, result.Error))
+			continue
+		}
+
+		// Name the repository in the heading of its document
+		builder.WriteString(strings.Replace(result.Result.Markdown, "# Repository Analysis\n\n", heading, 1))
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
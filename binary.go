@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	binarySniffSize      = 8000 // Same number of bytes git inspects when detecting binary files
+	maxNonPrintableRatio = 0.3  // Fraction of non-printable bytes above which a file is treated as binary
+)
+
+var (
+	utf16LEBOM = []byte{0xFF, 0xFE}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+)
+
+// isBinaryContent reports whether a sample taken from the start of a file looks like binary data.
+// UTF-16 text with a byte order mark is treated as text, otherwise any NUL byte or a high ratio
+// of non-printable characters marks the content as binary.
+func isBinaryContent(sample []byte) bool {
+	if len(sample) == 0 {
+		return false
+	}
+
+	if bytes.HasPrefix(sample, utf16LEBOM) || bytes.HasPrefix(sample, utf16BEBOM) {
+		return false
+	}
+
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return true
+	}
+
+	nonPrintable := 0
+	for i := 0; i < len(sample); {
+		r, size := utf8.DecodeRune(sample[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			// Ignore a multi-byte character that was cut off by the end of the sample
+			if len(sample)-i < utf8.UTFMax && !utf8.FullRune(sample[i:]) {
+				i = len(sample)
+				continue
+			}
+			nonPrintable++
+		case r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' && r != '\b' && r != 0x1b:
+			nonPrintable++
+		case r == 0x7f:
+			nonPrintable++
+		}
+		i += size
+	}
+
+	return float64(nonPrintable)/float64(len(sample)) > maxNonPrintableRatio
+}
+
+// readTextFile reads a file unless its first bytes identify it as binary, in which case
+// it returns isBinary without reading the rest. UTF-16 content is converted to UTF-8.
+func readTextFile(path string) (content string, isBinary bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+
+	sample := make([]byte, binarySniffSize)
+	n, err := io.ReadFull(file, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", false, err
+	}
+	sample = sample[:n]
+
+	if isBinaryContent(sample) {
+		return "", true, nil
+	}
+
+	rest, err := io.ReadAll(file)
+	if err != nil {
+		return "", false, err
+	}
+
+	return decodeText(append(sample, rest...)), false, nil
+}
+
+// decodeText converts UTF-16 content with a byte order mark to a UTF-8 string
+func decodeText(data []byte) string {
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(data, utf16LEBOM):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(data, utf16BEBOM):
+		order = binary.BigEndian
+	default:
+		return string(data)
+	}
+
+	data = data[2:]
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, order.Uint16(data[i:]))
+	}
+	return string(utf16.Decode(units))
+}
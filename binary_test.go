@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// encodeUTF16 encodes text as UTF-16 with a byte order mark
+func encodeUTF16(text string, order binary.ByteOrder) []byte {
+	var buf bytes.Buffer
+	if order == binary.LittleEndian {
+		buf.Write(utf16LEBOM)
+	} else {
+		buf.Write(utf16BEBOM)
+	}
+	for _, unit := range utf16.Encode([]rune(text)) {
+		binary.Write(&buf, order, unit)
+	}
+	return buf.Bytes()
+}
+
+// pngHeader is the signature and IHDR chunk of a 1x1 PNG image
+var pngHeader = []byte{
+	0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n',
+	0x00, 0x00, 0x00, 0x0d, 'I', 'H', 'D', 'R',
+	0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01,
+	0x08, 0x06, 0x00, 0x00, 0x00, 0x1f, 0x15, 0xc4, 0x89,
+}
+
+func TestIsBinaryContent(t *testing.T) {
+	utf8Text := "Grüße, 世界! café ☕\n"
+
+	tests := []struct {
+		name   string
+		sample []byte
+		want   bool
+	}{
+		{"empty", nil, false},
+		{"ascii", []byte("package main\n\nfunc main() {}\n"), false},
+		{"utf-8", []byte(utf8Text), false},
+		{"utf-8 cut off mid character", []byte(utf8Text)[:len("Grüße, 世")-1], false},
+		{"ansi escapes", []byte("\x1b[31mred\x1b[0m\n"), false},
+		{"utf-16le", encodeUTF16(utf8Text, binary.LittleEndian), false},
+		{"utf-16be", encodeUTF16(utf8Text, binary.BigEndian), false},
+		{"png", pngHeader, true},
+		{"nul byte", []byte("text\x00text"), true},
+		{"invalid utf-8", bytes.Repeat([]byte{0xff, 0xfe, 0xfd, 'a'}, 10)[2:], true},
+		{"control characters", bytes.Repeat([]byte{0x01, 0x02, 'a'}, 10), true},
+		{"few control characters", []byte(strings.Repeat("a", 20) + "\x01"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBinaryContent(tt.sample); got != tt.want {
+				t.Errorf("isBinaryContent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	text := "Grüße, 世界! 😀\n"
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"utf-8", []byte(text), text},
+		{"utf-16le", encodeUTF16(text, binary.LittleEndian), text},
+		{"utf-16be", encodeUTF16(text, binary.BigEndian), text},
+		{"odd trailing byte", append(encodeUTF16("ab", binary.LittleEndian), 'c'), "ab"},
+		{"bom only", utf16LEBOM, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeText(tt.data); got != tt.want {
+				t.Errorf("decodeText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadTextFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// The image data after the header is long enough that only the sample is inspected
+	image := write("image.png", append(pngHeader, make([]byte, 2*binarySniffSize)...))
+	if _, isBinary, err := readTextFile(image); err != nil || !isBinary {
+		t.Errorf("readTextFile(png) = binary %v, error %v, want binary", isBinary, err)
+	}
+
+	text := strings.Repeat("line ü\n", binarySniffSize)
+	utf16File := write("notes.txt", encodeUTF16(text, binary.LittleEndian))
+	content, isBinary, err := readTextFile(utf16File)
+	if err != nil || isBinary {
+		t.Fatalf("readTextFile(utf-16) = binary %v, error %v, want text", isBinary, err)
+	}
+	if content != text {
+		t.Errorf("readTextFile(utf-16) returned %d bytes, want %d bytes of the decoded text", len(content), len(text))
+	}
+}
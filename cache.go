@@ -0,0 +1,219 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheSize = 50        // Number of cached analyses when CACHE_SIZE is not set
+	defaultCacheTTL  = time.Hour // How long analyses are cached when CACHE_TTL is not set
+)
+
+// cacheEntry is a cached analysis together with the time it expires
+type cacheEntry struct {
+	key     string
+	resp    *RepoResponse
+	expires time.Time
+}
+
+// analysisCache keeps recent analyses in memory, keyed by repository, resolved commit and
+// request, and evicts the least recently used entry once it is full
+type analysisCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List // Most recently used first
+}
+
+// cache holds recent analyses, it is set up in main and stays nil when caching is disabled
+var cache *analysisCache
+
+// newAnalysisCache creates a cache for up to size analyses that expire after ttl
+func newAnalysisCache(size int, ttl time.Duration) *analysisCache {
+	return &analysisCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// cacheEnabled reports whether analyses are cached, from the CACHE_ENABLED env var
+func cacheEnabled() bool {
+	return os.Getenv("CACHE_ENABLED") != "false"
+}
+
+// cacheSize returns the maximum number of cached analyses from the CACHE_SIZE env var
+func cacheSize() int {
+	if value := os.Getenv("CACHE_SIZE"); value != "" {
+		if size, err := strconv.Atoi(value); err == nil && size > 0 {
+			return size
+		}
+		log.Printf("Warning: Invalid CACHE_SIZE %q, using default of %d", value, defaultCacheSize)
+	}
+	return defaultCacheSize
+}
+
+// cacheTTL returns how long analyses are cached from the CACHE_TTL env var
+func cacheTTL() time.Duration {
+	if value := os.Getenv("CACHE_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err == nil && ttl > 0 {
+			return ttl
+		}
+		log.Printf("Warning: Invalid CACHE_TTL %q, using default of %v", value, defaultCacheTTL)
+	}
+	return defaultCacheTTL
+}
+
+// key returns the cache key of a request. The requested ref is resolved with git ls-remote,
+// so a moved branch misses the cache. It returns "" when the request cannot be cached,
+// for example because the remote cannot be reached or only an abbreviated SHA is given.
+func (c *analysisCache) key(ctx context.Context, req *RepoRequest) string {
+	// Local directories have no revision to tell whether they changed
+	if c == nil || req.LocalPath != "" {
+		return ""
+	}
+
+	// A diff base other than a full commit SHA may move as well
+	if req.DiffBase != "" && (len(req.DiffBase) != 40 || !commitPattern.MatchString(req.DiffBase)) {
+		return ""
+	}
+
+	commit, err := resolveCommit(ctx, req)
+	if err != nil {
+		log.Printf("Warning: Could not resolve revision for caching: %v", err)
+		return ""
+	}
+	if commit == "" {
+		return ""
+	}
+
+	// Credentials do not change the result. Anyone without access fails to resolve the ref.
+	fingerprint := *req
+	fingerprint.Auth = nil
+	data, err := json.Marshal(fingerprint)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(commit + "\n" + string(data)))
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns the cached analysis for a key, if there is one that has not expired
+func (c *analysisCache) get(key string) (*RepoResponse, bool) {
+	if c == nil || key == "" {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.resp, true
+}
+
+// put stores an analysis, evicting the least recently used entries if the cache is full
+func (c *analysisCache) put(key string, resp *RepoResponse) {
+	if c == nil || key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{key: key, resp: resp, expires: time.Now().Add(c.ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// documentKey returns the key under which the streamed markdown document of a request is
+// cached. It differs from the key of the complete analysis, since the document alone cannot
+// serve the other formats.
+func documentKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	return key + "-document"
+}
+
+// getStreamed returns a cached analysis that can serve a streamed format: the complete
+// analysis, or for markdown and text also the document kept from an earlier streamed response
+func (c *analysisCache) getStreamed(key, format string) (*RepoResponse, bool) {
+	if resp, ok := c.get(key); ok {
+		return resp, true
+	}
+	if isDocumentFormat(format) {
+		return c.get(documentKey(key))
+	}
+	return nil, false
+}
+
+// resolveCommit returns the commit SHA the requested branch, tag or default branch
+// currently points to. Full commit SHAs are used as they are, abbreviated ones cannot
+// be resolved without cloning and yield "".
+func resolveCommit(ctx context.Context, req *RepoRequest) (string, error) {
+	if req.Commit != "" {
+		if len(req.Commit) == 40 {
+			return strings.ToLower(req.Commit), nil
+		}
+		return "", nil
+	}
+
+	ref := "HEAD"
+	if req.Branch != "" {
+		ref = "refs/heads/" + req.Branch
+	} else if req.Tag != "" {
+		ref = "refs/tags/" + req.Tag
+	}
+
+	auth, err := prepareAuth(req)
+	defer auth.cleanup()
+	if err != nil {
+		return "", err
+	}
+
+	output, err := runGit(ctx, auth, "ls-remote", "--", req.RepoURL, ref)
+	if err != nil {
+		return "", fmt.Errorf("git ls-remote failed: %v - %s", err, output)
+	}
+
+	// Each line is "<sha>\t<ref>", the first one is the requested ref itself
+	fields := strings.Fields(output)
+	if len(fields) == 0 || !commitPattern.MatchString(fields[0]) {
+		return "", fmt.Errorf("ref %s not found", ref)
+	}
+	return fields[0], nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chdirTemp changes into a new temporary directory for the rest of the test and creates
+// tempDir in it, so working directories are created there
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runTestGit runs git in dir and fails the test on errors
+func runTestGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	args = append([]string{"-C", dir, "-c", "user.name=Test", "-c", "user.email=test@example.com", "-c", "commit.gpgsign=false"}, args...)
+	output, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed: %v - %s", args, err, output)
+	}
+	return string(output)
+}
+
+// writeTestFiles writes files relative to dir, creating their directories
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTestRepo creates a git repository with one commit of files and returns its path
+func newTestRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	runTestGit(t, dir, "init", "--quiet")
+	writeTestFiles(t, dir, files)
+	runTestGit(t, dir, "add", "-A")
+	runTestGit(t, dir, "commit", "--quiet", "-m", "Initial commit")
+	return dir
+}
+
+// serveTestRepo serves a copy of a test repository over HTTP with git http-backend, allows
+// its host and returns its URL
+func serveTestRepo(t *testing.T, repo string) string {
+	t.Helper()
+	root := t.TempDir()
+	runTestGit(t, root, "clone", "--quiet", "--bare", repo, filepath.Join(root, "repo.git"))
+	execPath := strings.TrimSpace(runTestGit(t, root, "--exec-path"))
+
+	server := httptest.NewServer(&cgi.Handler{
+		Path: filepath.Join(execPath, "git-http-backend"),
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	})
+	t.Cleanup(server.Close)
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ALLOWED_GIT_HOSTS", serverURL.Hostname())
+	return server.URL + "/repo.git"
+}
+
+// cloneCount returns the number of clones the metrics have recorded
+func cloneCount(t *testing.T, m *metricsRegistry) uint64 {
+	t.Helper()
+	families, err := m.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var count uint64
+	for _, family := range families {
+		if family.GetName() != "gitdump_clone_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			count += metric.GetHistogram().GetSampleCount()
+		}
+	}
+	return count
+}
+
+func TestRunAnalysisCachedRequestDoesNotClone(t *testing.T) {
+	repo := newTestRepo(t, map[string]string{"main.go": "package main\n", "README.md": "# Test\n"})
+	chdirTemp(t)
+
+	oldCache, oldMetrics := cache, metrics
+	cache, metrics = newAnalysisCache(defaultCacheSize, time.Minute), newMetricsRegistry()
+	defer func() { cache, metrics = oldCache, oldMetrics }()
+
+	req := RepoRequest{RepoURL: repo}
+	first, repoID, err := runAnalysis(context.Background(), &req)
+	if err != nil {
+		t.Fatalf("first analysis failed: %v", err)
+	}
+	if repoID == "" {
+		t.Fatal("first analysis was served from the cache")
+	}
+	if got := cloneCount(t, metrics); got != 1 {
+		t.Fatalf("first analysis cloned %d times, want 1", got)
+	}
+
+	second, repoID, err := runAnalysis(context.Background(), &req)
+	if err != nil {
+		t.Fatalf("second analysis failed: %v", err)
+	}
+	if repoID != "" {
+		t.Errorf("second analysis got repo ID %q, want a cache hit", repoID)
+	}
+	if got := cloneCount(t, metrics); got != 1 {
+		t.Errorf("second analysis cloned again, %d clones in total", got)
+	}
+	if second != first {
+		t.Error("second analysis did not return the cached response")
+	}
+
+	// A new commit moves HEAD, so the next request misses the cache
+	writeTestFiles(t, repo, map[string]string{"main.go": "package main\n\nfunc main() {}\n"})
+	runTestGit(t, repo, "commit", "--quiet", "-am", "Add main")
+	if _, repoID, err = runAnalysis(context.Background(), &req); err != nil {
+		t.Fatalf("third analysis failed: %v", err)
+	}
+	if repoID == "" {
+		t.Error("analysis of a new commit was served from the cache")
+	}
+	if got := cloneCount(t, metrics); got != 2 {
+		t.Errorf("analysis of a new commit made %d clones in total, want 2", got)
+	}
+}
+
+func TestAnalysisCacheExpires(t *testing.T) {
+	c := newAnalysisCache(1, time.Millisecond)
+	c.put("key", &RepoResponse{})
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.get("key"); ok {
+		t.Error("expired entry was returned")
+	}
+}
+
+func TestAnalysisCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newAnalysisCache(2, time.Minute)
+	c.put("a", &RepoResponse{})
+	c.put("b", &RepoResponse{})
+	c.get("a")
+	c.put("c", &RepoResponse{})
+
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.get(key); !ok {
+			t.Errorf("entry %q was evicted", key)
+		}
+	}
+}
+
+func TestStreamedMarkdownCachedRequestDoesNotClone(t *testing.T) {
+	repoURL := serveTestRepo(t, newTestRepo(t, map[string]string{"main.go": "package main\n", "README.md": "# Test\n"}))
+	chdirTemp(t)
+
+	oldCache, oldMetrics := cache, metrics
+	cache, metrics = newAnalysisCache(defaultCacheSize, time.Minute), newMetricsRegistry()
+	defer func() { cache, metrics = oldCache, oldMetrics }()
+
+	// analyze requests the analysis in a format and returns the response body
+	analyze := func(format string) string {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/analyze?format="+format+"&repo_url="+url.QueryEscape(repoURL), nil)
+		w := httptest.NewRecorder()
+		handleAnalyzeRepo(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s analysis returned %d: %s", format, w.Code, w.Body.String())
+		}
+		if w.Header().Get(repoIDHeader) == "" {
+			t.Errorf("%s analysis has no %s header", format, repoIDHeader)
+		}
+		return w.Body.String()
+	}
+
+	first := analyze("markdown")
+	if got := cloneCount(t, metrics); got != 1 {
+		t.Fatalf("first analysis cloned %d times, want 1", got)
+	}
+	if !strings.Contains(first, "### main.go") {
+		t.Fatalf("first analysis is missing main.go:\n%s", first)
+	}
+
+	second := analyze("markdown")
+	if got := cloneCount(t, metrics); got != 1 {
+		t.Errorf("second markdown analysis cloned again, %d clones in total", got)
+	}
+	if second != first {
+		t.Errorf("cached markdown differs from the streamed one:\n%s\nwant:\n%s", second, first)
+	}
+
+	// The text document is derived from the same cached document
+	text := analyze("text")
+	if got := cloneCount(t, metrics); got != 1 {
+		t.Errorf("text analysis cloned again, %d clones in total", got)
+	}
+	if !strings.HasPrefix(text, "# Directory Tree\n\n") || !strings.Contains(text, "# File Contents\n\n") {
+		t.Errorf("cached text document is malformed:\n%s", text)
+	}
+
+	// The document alone cannot serve other formats, which are analyzed as a whole
+	analyze("json")
+	if got := cloneCount(t, metrics); got != 2 {
+		t.Errorf("json analysis made %d clones in total, want 2", got)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// ChangeStatus describes how a file changed between the diff base and the analyzed revision
+type ChangeStatus string
+
+const (
+	ChangeAdded    ChangeStatus = "added"
+	ChangeModified ChangeStatus = "modified"
+	ChangeDeleted  ChangeStatus = "deleted"
+)
+
+// FileChange records a file that differs between the diff base and the analyzed revision
+type FileChange struct {
+	Path   string       `json:"path"`
+	Status ChangeStatus `json:"status"`
+}
+
+// diffChanges returns the files that differ between the diff base of a request and the
+// checked out revision. The base is fetched on top of the clone, so it does not need to
+// share the shallow history of the analyzed revision.
+func diffChanges(ctx context.Context, auth *gitAuth, repoDir string, req *RepoRequest) ([]FileChange, error) {
+	base, err := fetchDiffBase(ctx, auth, repoDir, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Renames are reported as a deleted and an added file
+	output, err := runGit(ctx, auth, "-C", repoDir, "diff", "--name-status", "--no-renames", "-z", base, "HEAD", "--")
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed: %v - %s", err, output)
+	}
+
+	// The output alternates between a status letter and a path, each terminated by NUL
+	var changes []FileChange
+	fields := strings.Split(strings.TrimSuffix(output, "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		change := FileChange{Path: fields[i+1], Status: ChangeModified}
+		switch fields[i] {
+		case "A":
+			change.Status = ChangeAdded
+		case "D":
+			change.Status = ChangeDeleted
+		}
+		changes = append(changes, change)
+	}
+
+	log.Printf("Found %s since %s", pluralize(len(changes), "changed file", "changed files"), req.DiffBase)
+	return changes, nil
+}
+
+// fetchDiffBase makes the diff base of a request available in the clone and returns the
+// revision to diff against. Commits that are part of the clone are used directly.
+func fetchDiffBase(ctx context.Context, auth *gitAuth, repoDir string, req *RepoRequest) (string, error) {
+	if commitPattern.MatchString(req.DiffBase) {
+		if _, err := runGit(ctx, auth, "-C", repoDir, "rev-parse", "--verify", "--quiet", req.DiffBase+"^{commit}"); err == nil {
+			return req.DiffBase, nil
+		}
+	}
+
+	args := []string{"-C", repoDir, "fetch", "--quiet", "--no-tags"}
+	if depth := cloneDepth(req); depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(depth))
+	}
+	args = append(args, "origin", "--", req.DiffBase)
+
+	log.Printf("Fetching diff base %s", req.DiffBase)
+	output, err := runGit(ctx, auth, args...)
+	if err != nil {
+		if isRefNotFoundOutput(output) {
+			return "", fmt.Errorf("%w: diff base %q does not exist", errRefNotFound, req.DiffBase)
+		}
+		return "", fmt.Errorf("git fetch of diff base failed: %v - %s", err, output)
+	}
+	return "FETCH_HEAD", nil
+}
+
+// changedPaths returns the paths that still exist after the changes of a diff request, or
+// nil when the request is not a diff
+func (req *RepoRequest) changedPaths() map[string]bool {
+	if req.DiffBase == "" {
+		return nil
+	}
+
+	paths := make(map[string]bool, len(req.changes))
+	for _, change := range req.changes {
+		if change.Status != ChangeDeleted {
+			paths[change.Path] = true
+		}
+	}
+	return paths
+}
+
+// writeChangesSection writes the list of changed files. Deleted files are only listed,
+// since they have no contents in the analyzed revision.
+func writeChangesSection(w io.Writer, base string, changes []FileChange) {
+	fmt.Fprintf(w, "## Changes since %s (%d)\n\n", base, len(changes))
+	for _, change := range changes {
+		if change.Status == ChangeDeleted {
+			fmt.Fprintf(w, "- %s (deleted, contents not included)\n", change.Path)
+			continue
+		}
+		fmt.Fprintf(w, "- %s (%s)\n", change.Path, change.Status)
+	}
+	io.WriteString(w, "\n")
+}
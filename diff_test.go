@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// newDiffTestRepo creates a repository with two commits and returns its path and the SHA
+// of the first commit, which is also tagged v1. The second commit modifies, deletes, adds
+// and renames a file.
+func newDiffTestRepo(t *testing.T) (string, string) {
+	t.Helper()
+	repo := newTestRepo(t, map[string]string{
+		"modified.go": "package main\n",
+		"deleted.go":  "package main\n",
+		"renamed.go":  "package main\n\nfunc renamed() {}\n",
+		"same.go":     "package main\n",
+	})
+	base := strings.TrimSpace(runTestGit(t, repo, "rev-parse", "HEAD"))
+	runTestGit(t, repo, "tag", "v1")
+
+	writeTestFiles(t, repo, map[string]string{
+		"modified.go":  "package main\n\nfunc modified() {}\n",
+		"added/new.go": "package added\n",
+	})
+	if err := os.Remove(filepath.Join(repo, "deleted.go")); err != nil {
+		t.Fatal(err)
+	}
+	runTestGit(t, repo, "mv", "renamed.go", "moved.go")
+	runTestGit(t, repo, "add", "-A")
+	runTestGit(t, repo, "commit", "--quiet", "-m", "Change files")
+	return repo, base
+}
+
+// sortedChanges returns changes ordered by path
+func sortedChanges(changes []FileChange) []FileChange {
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func TestDiffChanges(t *testing.T) {
+	repo, base := newDiffTestRepo(t)
+	want := []FileChange{
+		{Path: "added/new.go", Status: ChangeAdded},
+		{Path: "deleted.go", Status: ChangeDeleted},
+		{Path: "modified.go", Status: ChangeModified},
+		{Path: "moved.go", Status: ChangeAdded},
+		{Path: "renamed.go", Status: ChangeDeleted},
+	}
+
+	t.Run("commit in the clone", func(t *testing.T) {
+		changes, err := diffChanges(context.Background(), nil, repo, &RepoRequest{DiffBase: base})
+		if err != nil {
+			t.Fatalf("diffChanges failed: %v", err)
+		}
+		if got := sortedChanges(changes); !reflect.DeepEqual(got, want) {
+			t.Errorf("diffChanges() = %+v, want %+v", got, want)
+		}
+	})
+
+	// A shallow clone of the second commit does not contain the base, so it is fetched
+	clone := filepath.Join(t.TempDir(), "clone")
+	runTestGit(t, filepath.Dir(clone), "clone", "--quiet", "--depth", "1", "file://"+repo, clone)
+
+	t.Run("fetched tag", func(t *testing.T) {
+		changes, err := diffChanges(context.Background(), nil, clone, &RepoRequest{DiffBase: "v1"})
+		if err != nil {
+			t.Fatalf("diffChanges failed: %v", err)
+		}
+		if got := sortedChanges(changes); !reflect.DeepEqual(got, want) {
+			t.Errorf("diffChanges() = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("missing ref", func(t *testing.T) {
+		_, err := diffChanges(context.Background(), nil, clone, &RepoRequest{DiffBase: "does-not-exist"})
+		if !errors.Is(err, errRefNotFound) {
+			t.Errorf("diffChanges() error = %v, want errRefNotFound", err)
+		}
+	})
+
+	t.Run("no changes", func(t *testing.T) {
+		head := strings.TrimSpace(runTestGit(t, repo, "rev-parse", "HEAD"))
+		changes, err := diffChanges(context.Background(), nil, repo, &RepoRequest{DiffBase: head})
+		if err != nil {
+			t.Fatalf("diffChanges failed: %v", err)
+		}
+		if len(changes) != 0 {
+			t.Errorf("diffChanges() = %+v, want no changes", changes)
+		}
+	})
+}
+
+func TestChangedPaths(t *testing.T) {
+	if paths := (&RepoRequest{}).changedPaths(); paths != nil {
+		t.Errorf("changedPaths() = %v without a diff base, want nil", paths)
+	}
+
+	req := &RepoRequest{DiffBase: "main", changes: []FileChange{
+		{Path: "a.go", Status: ChangeAdded},
+		{Path: "b.go", Status: ChangeModified},
+		{Path: "c.go", Status: ChangeDeleted},
+	}}
+	want := map[string]bool{"a.go": true, "b.go": true}
+	if got := req.changedPaths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("changedPaths() = %v, want %v", got, want)
+	}
+}
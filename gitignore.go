@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ignoreRule is a single pattern from a .gitignore file
+type ignoreRule struct {
+	base    string // Directory of the .gitignore file relative to the repo root, "" for the root
+	pattern string // Glob pattern relative to base
+	negate  bool   // Pattern started with "!" and re-includes matching paths
+	dirOnly bool   // Pattern ended with "/" and only matches directories
+}
+
+// gitignoreMatcher evaluates the combined rules of every .gitignore file in a repository
+type gitignoreMatcher struct {
+	rules []ignoreRule
+}
+
+// loadGitignore collects the rules of the root and all nested .gitignore files in a repository
+func loadGitignore(repoDir string) *gitignoreMatcher {
+	matcher := &gitignoreMatcher{}
+
+	filepath.Walk(repoDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Continue despite errors
+		}
+
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.Name() != ".gitignore" {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+
+		base, err := filepath.Rel(repoDir, filepath.Dir(path))
+		if err != nil {
+			return nil
+		}
+		base = filepath.ToSlash(base)
+		if base == "." {
+			base = ""
+		}
+
+		matcher.rules = append(matcher.rules, parseGitignore(base, data)...)
+		return nil
+	})
+
+	// Rules in deeper .gitignore files take precedence, so evaluate them last
+	sort.SliceStable(matcher.rules, func(i, j int) bool {
+		return ignoreDepth(matcher.rules[i].base) < ignoreDepth(matcher.rules[j].base)
+	})
+
+	return matcher
+}
+
+// loadRootIgnoreFile parses a single file in gitignore syntax at the repo root, such as
+// .dumpignore, returning nil if there is none or it has no rules
+func loadRootIgnoreFile(repoDir, name string) *gitignoreMatcher {
+	data, err := os.ReadFile(filepath.Join(repoDir, name))
+	if err != nil {
+		return nil
+	}
+
+	rules := parseGitignore("", data)
+	if len(rules) == 0 {
+		return nil
+	}
+	return &gitignoreMatcher{rules: rules}
+}
+
+// ignoreDepth returns the number of directory levels below the repo root
+func ignoreDepth(base string) int {
+	if base == "" {
+		return 0
+	}
+	return strings.Count(base, "/") + 1
+}
+
+// parseGitignore parses the contents of a .gitignore file located in the base directory
+func parseGitignore(base string, data []byte) []ignoreRule {
+	var rules []ignoreRule
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		// Trailing spaces are ignored unless escaped
+		if !strings.HasSuffix(line, "\\ ") {
+			line = strings.TrimRight(line, " ")
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: base}
+
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+			line = line[1:]
+		}
+
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+
+		// A pattern without a slash matches at any depth below the .gitignore file,
+		// otherwise it is anchored to the directory containing the .gitignore file
+		if strings.Contains(line, "/") {
+			line = strings.TrimPrefix(line, "/")
+		} else {
+			line = "**/" + line
+		}
+
+		if line == "" {
+			continue
+		}
+
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// isIgnored reports whether a slash-separated path relative to the repo root is ignored.
+// As in git, a path inside an ignored directory cannot be re-included by a negation.
+func (m *gitignoreMatcher) isIgnored(relPath string, isDir bool) bool {
+	if m == nil || len(m.rules) == 0 {
+		return false
+	}
+
+	segments := strings.Split(relPath, "/")
+	for i := 1; i < len(segments); i++ {
+		if m.match(strings.Join(segments[:i], "/"), true) {
+			return true
+		}
+	}
+
+	return m.match(relPath, isDir)
+}
+
+// match applies every rule to a single path, the last matching rule wins
+func (m *gitignoreMatcher) match(relPath string, isDir bool) bool {
+	ignored := false
+
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		rel := relPath
+		if rule.base != "" {
+			if !strings.HasPrefix(relPath, rule.base+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(relPath, rule.base+"/")
+		}
+
+		if matchGlob(rule.pattern, rel) {
+			ignored = !rule.negate
+		}
+	}
+
+	return ignored
+}
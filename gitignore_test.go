@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCollectCandidatesDumpignore(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		".dumpignore":           "# Documentation is published separately\ndocs/\n*.generated.go\n",
+		"main.go":               "package main\n",
+		"api.generated.go":      "package main\n",
+		"docs/index.md":         "# Docs\n",
+		"docs/api/reference.md": "# Reference\n",
+		"pkg/docs/notes.md":     "# Notes\n",
+		"pkg/lib.go":            "package pkg\n",
+		"docs.md":               "# Not a directory\n",
+	})
+
+	candidates, skipped, err := collectCandidates(context.Background(), dir, &RepoRequest{})
+	if err != nil {
+		t.Fatalf("collectCandidates failed: %v", err)
+	}
+
+	var paths []string
+	for _, candidate := range candidates {
+		paths = append(paths, candidate.relPath)
+	}
+	want := []string{".dumpignore", "docs.md", "main.go", "pkg/lib.go"}
+	if got := sortedStrings(paths); !reflect.DeepEqual(got, want) {
+		t.Errorf("collected %q, want %q", got, want)
+	}
+
+	// Ignored directories are reported once instead of file by file
+	reasons := make(map[string]SkipReason)
+	for _, file := range skipped {
+		reasons[file.Path] = file.Reason
+	}
+	for _, path := range []string{"docs/", "pkg/docs/", "api.generated.go"} {
+		if reasons[path] != SkipDumpignored {
+			t.Errorf("%s skipped with reason %q, want %q", path, reasons[path], SkipDumpignored)
+		}
+	}
+	if _, ok := reasons["docs/index.md"]; ok {
+		t.Error("files of an ignored directory were reported one by one")
+	}
+}
+
+func TestCollectCandidatesDumpignoreExplicitInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		".dumpignore":   "docs/\n",
+		"docs/index.md": "# Docs\n",
+		"docs/guide.md": "# Guide\n",
+	})
+
+	// The owner's exclusions apply to files that are included explicitly as well
+	got := collectPaths(t, dir, &RepoRequest{Dirs: []DirRequest{{Path: "docs/index.md"}, {Path: "docs"}}})
+	if len(got) != 0 {
+		t.Errorf("collected %q from an ignored directory", got)
+	}
+}
+
+func TestCollectCandidatesDumpinclude(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		".dumpinclude":  "src/\nREADME.md\n",
+		"README.md":     "# Project\n",
+		"src/main.go":   "package main\n",
+		"docs/index.md": "# Docs\n",
+		"Makefile":      "all:\n",
+	})
+
+	want := []string{"README.md", "src/main.go"}
+	if got := collectPaths(t, dir, &RepoRequest{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("collected %q, want %q", got, want)
+	}
+}
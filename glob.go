@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxPatternSegments is the number of path segments a glob pattern may have. Patterns come
+// from requests and from the .gitignore files of cloned repositories, so their size is bounded.
+const maxPatternSegments = 64
+
+// isGlobPattern reports whether a path contains glob wildcard characters
+func isGlobPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// normalizePattern converts a user supplied path or pattern to a clean, slash-separated form
+func normalizePattern(pattern string) string {
+	return path.Clean(strings.TrimPrefix(filepath.ToSlash(pattern), "/"))
+}
+
+// validatePatternSize checks that a path or glob pattern has no more than maxPatternSegments segments
+func validatePatternSize(pattern string) error {
+	if strings.Count(pattern, "/") >= maxPatternSegments {
+		return fmt.Errorf("pattern %q has more than %d path segments", pattern, maxPatternSegments)
+	}
+	return nil
+}
+
+// matchGlob reports whether a slash-separated path matches a glob pattern.
+// Each segment is matched with path.Match semantics, and a "**" segment
+// matches zero or more directories. Patterns with more than maxPatternSegments
+// segments never match.
+func matchGlob(pattern, name string) bool {
+	segments := collapseDoubleStars(strings.Split(pattern, "/"))
+	if len(segments) > maxPatternSegments {
+		return false
+	}
+	return matchSegments(segments, strings.Split(name, "/"))
+}
+
+// collapseDoubleStars replaces runs of "**" segments by a single one, which matches the same paths
+func collapseDoubleStars(segments []string) []string {
+	collapsed := segments[:0:0]
+	for i, segment := range segments {
+		if segment == "**" && i > 0 && segments[i-1] == "**" {
+			continue
+		}
+		collapsed = append(collapsed, segment)
+	}
+	return collapsed
+}
+
+// matchSegments matches path segments against pattern segments, expanding "**". Each pair of
+// pattern and name positions is decided once, so the cost is bounded by the product of their
+// lengths however many "**" segments the pattern has.
+func matchSegments(pattern, name []string) bool {
+	// matches[j] reports whether pattern[i+1:] matches name[j:] while row i is computed
+	matches := make([]bool, len(name)+1)
+	matches[len(name)] = true
+
+	row := make([]bool, len(name)+1)
+	for i := len(pattern) - 1; i >= 0; i-- {
+		if pattern[i] == "**" {
+			// "**" consumes no segment, or one more segment and stays in place
+			row[len(name)] = matches[len(name)]
+			for j := len(name) - 1; j >= 0; j-- {
+				row[j] = matches[j] || row[j+1]
+			}
+		} else {
+			row[len(name)] = false
+			for j := len(name) - 1; j >= 0; j-- {
+				row[j] = false
+				if matches[j+1] {
+					ok, err := path.Match(pattern[i], name[j])
+					row[j] = err == nil && ok
+				}
+			}
+		}
+		matches, row = row, matches
+	}
+
+	return matches[0]
+}
+
+// matchesPathOrParent reports whether a pattern matches a path or any of its parent
+// directories, so that a pattern matching a directory covers every file inside it
+func matchesPathOrParent(pattern, relPath string) bool {
+	for p := relPath; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		if matchGlob(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExcludedPath reports whether a slash-separated relative path is covered by an
+// exclude entry, either as an exact path, a file inside an excluded directory or a glob match
+func isExcludedPath(relPath string, excludePaths []string) bool {
+	for _, excludePath := range excludePaths {
+		excludePath = normalizePattern(excludePath)
+
+		if relPath == excludePath || strings.HasPrefix(relPath, excludePath+"/") {
+			return true
+		}
+		if isGlobPattern(excludePath) && matchesPathOrParent(excludePath, relPath) {
+			return true
+		}
+	}
+	return false
+}
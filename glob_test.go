@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/main.go", false},
+		{"src/*.go", "src/main.go", true},
+		{"src/*.go", "src/pkg/main.go", false},
+		{"src/?.go", "src/a.go", true},
+		{"src/[ab].go", "src/c.go", false},
+
+		// "**" matches zero or more directories
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "cmd/server/main.go", true},
+		{"src/**/*.go", "src/main.go", true},
+		{"src/**/*.go", "src/a/b/c/main.go", true},
+		{"src/**/*.go", "lib/main.go", false},
+		{"src/**", "src", true},
+		{"src/**", "src/a/b.txt", true},
+		{"**/test/**", "pkg/test/data/a.json", true},
+		{"**/test/**", "pkg/testing/a.json", false},
+		{"a/**/b/**/c", "a/b/c", true},
+		{"a/**/b/**/c", "a/x/b/y/z/c", true},
+		{"a/**/b/**/c", "a/x/y/c", false},
+		{"**", "any/depth/file.txt", true},
+
+		// Patterns match whole segments only
+		{"src", "src/main.go", false},
+		{"docs/*", "docs", false},
+
+		// Malformed patterns never match
+		{"[", "[", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsExcludedPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		relPath  string
+		excludes []string
+		want     bool
+	}{
+		{"exact file", "config/secrets.yaml", []string{"config/secrets.yaml"}, true},
+		{"file inside directory", "vendor/lib/a.go", []string{"vendor"}, true},
+		{"directory with trailing slash", "vendor/lib/a.go", []string{"vendor/"}, true},
+		{"leading slash", "vendor/lib/a.go", []string{"/vendor"}, true},
+		{"directory itself", "vendor", []string{"vendor"}, true},
+		{"prefix of a name", "vendored/a.go", []string{"vendor"}, false},
+		{"file named like a directory", "build", []string{"build/"}, true},
+		{"unrelated", "src/main.go", []string{"vendor", "docs"}, false},
+
+		{"glob file", "src/main_test.go", []string{"**/*_test.go"}, true},
+		{"glob file at root", "main_test.go", []string{"**/*_test.go"}, true},
+		{"glob not matching", "src/main.go", []string{"**/*_test.go"}, false},
+		{"glob matching a parent directory", "pkg/testdata/fixtures/a.json", []string{"**/testdata"}, true},
+		{"glob matching a nested parent", "a/b/node_modules/c/d.js", []string{"**/node_modules"}, true},
+		{"glob directory contents", "docs/api/index.md", []string{"docs/**"}, true},
+		{"single star stays in its segment", "src/pkg/main.go", []string{"src/*.go"}, false},
+		{"single star directory", "build/out/app", []string{"*/out"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isExcludedPath(tt.relPath, tt.excludes); got != tt.want {
+				t.Errorf("isExcludedPath(%q, %q) = %v, want %v", tt.relPath, tt.excludes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchGlobCollapsesDoubleStars(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"**/**/*.go", "main.go", true},
+		{"src/**/**/**/*.go", "src/a/b/main.go", true},
+		{"**/**", "a/b", true},
+		{"src/**/**/lib", "src/pkg", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchGlobPathologicalPattern(t *testing.T) {
+	deepPath := strings.TrimSuffix(strings.Repeat("a/", 13), "/") + "/file.txt"
+	longPath := strings.TrimSuffix(strings.Repeat("a/", 60), "/")
+
+	patterns := []struct {
+		pattern string
+		name    string
+	}{
+		// Consecutive "**" segments, which backtracking expands exponentially
+		{strings.Repeat("**/", 16) + "b", deepPath},
+		// "**" separated by segments that match everywhere cannot be collapsed
+		{strings.Repeat("**/a*/", 30) + "b", longPath},
+		{strings.Repeat("**/?/", 31) + "**", longPath + "/x"},
+	}
+
+	start := time.Now()
+	for _, p := range patterns {
+		for i := 0; i < 100; i++ {
+			matchGlob(p.pattern, p.name)
+			isExcludedPath(p.name, []string{p.pattern})
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("matching pathological patterns took %v, want well below a second", elapsed)
+	}
+}
+
+func TestMatchGlobPatternSize(t *testing.T) {
+	tooLong := strings.Repeat("*/", maxPatternSegments) + "*"
+	if matchGlob(tooLong, strings.Repeat("a/", maxPatternSegments)+"a") {
+		t.Error("pattern with too many segments matched")
+	}
+	if err := validatePatternSize(tooLong); err == nil {
+		t.Error("pattern with too many segments was accepted")
+	}
+	if err := validateDirs([]DirRequest{{Path: tooLong, Exclude: true}}); err == nil {
+		t.Error("dirs with a pattern of too many segments were accepted")
+	}
+
+	longest := strings.TrimSuffix(strings.Repeat("*/", maxPatternSegments), "/")
+	if err := validatePatternSize(longest); err != nil {
+		t.Errorf("pattern of %d segments was rejected: %v", maxPatternSegments, err)
+	}
+	if !matchGlob(longest, strings.TrimSuffix(strings.Repeat("a/", maxPatternSegments), "/")) {
+		t.Errorf("pattern of %d segments did not match", maxPatternSegments)
+	}
+}
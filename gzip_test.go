@@ -0,0 +1,147 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveGzip runs handler behind gzipMiddleware for a request with the Accept-Encoding header
+func serveGzip(t *testing.T, handler http.HandlerFunc, acceptEncoding string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/analyze", nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	gzipMiddleware(handler)(w, r)
+	return w
+}
+
+// gunzip decompresses a response body
+func gunzip(t *testing.T, body io.Reader) string {
+	t.Helper()
+	zr, err := gzip.NewReader(body)
+	if err != nil {
+		t.Fatalf("response is not gzip compressed: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("failed to decompress response: %v", err)
+	}
+	return string(data)
+}
+
+func TestGzipMiddlewareRoundTrip(t *testing.T) {
+	document := strings.Repeat("# Repository Analysis\n\nfunc main() {}\n", 1000)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/markdown")
+		w.Header().Set("Content-Length", "123") // Stale once compressed
+		io.WriteString(w, document[:len(document)/2])
+		http.NewResponseController(w).Flush()
+		io.WriteString(w, document[len(document)/2:])
+	}
+
+	w := serveGzip(t, handler, "deflate, gzip;q=0.8")
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	if got := w.Header().Get("Content-Length"); got != "" {
+		t.Errorf("Content-Length = %q, want it removed", got)
+	}
+	if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", got)
+	}
+	if w.Body.Len() >= len(document) {
+		t.Errorf("compressed body has %d bytes, the document %d", w.Body.Len(), len(document))
+	}
+	if got := gunzip(t, w.Body); got != document {
+		t.Errorf("round trip returned %d bytes that differ from the %d bytes written", len(got), len(document))
+	}
+}
+
+func TestGzipMiddlewareUncompressed(t *testing.T) {
+	body := "PK\x03\x04 archive"
+	typed := func(contentType string, status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			w.WriteHeader(status)
+			if status != http.StatusNoContent {
+				io.WriteString(w, body)
+			}
+		}
+	}
+
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		acceptEncoding string
+	}{
+		{"gzip not accepted", typed("text/markdown", http.StatusOK), ""},
+		{"other encoding", typed("text/markdown", http.StatusOK), "br, deflate"},
+		{"gzip refused", typed("text/markdown", http.StatusOK), "gzip;q=0, br"},
+		{"zip archive", typed("application/zip", http.StatusOK), "gzip"},
+		{"unknown content type", typed("", http.StatusOK), "gzip"},
+		{"no content", typed("application/json", http.StatusNoContent), "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveGzip(t, tt.handler, tt.acceptEncoding)
+			if got := w.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("Content-Encoding = %q, want none", got)
+			}
+			if w.Code != http.StatusNoContent && w.Body.String() != body {
+				t.Errorf("body = %q, want %q as written", w.Body.String(), body)
+			}
+		})
+	}
+}
+
+func TestGzipMiddlewareErrorStatus(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, "Job not found")
+	}
+
+	w := serveGzip(t, handler, "gzip")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if got := gunzip(t, w.Body); !strings.Contains(got, "Job not found") {
+		t.Errorf("decompressed body = %q, want the error", got)
+	}
+}
+
+func TestHandleGetJobCompressed(t *testing.T) {
+	oldJobs := jobs
+	jobs = newJobStore(defaultJobTTL)
+	defer func() { jobs = oldJobs }()
+
+	job, err := jobs.create(RepoRequest{RepoURL: "https://github.com/org/repo.git"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs.update(job.ID, func(job *Job) {
+		job.Status = JobDone
+		job.Result = &RepoResponse{Contents: map[string]string{"main.go": strings.Repeat("package main\n", 500)}}
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jobs/{id}", gzipMiddleware(handleGetJob))
+	r := httptest.NewRequest(http.MethodGet, "/jobs/"+job.ID, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	if got := gunzip(t, w.Body); !strings.Contains(got, `"status":"done"`) {
+		t.Errorf("decompressed job = %.200q, want the job status", got)
+	}
+}
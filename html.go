@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+)
+
+const (
+	highlightJSScript     = "https://cdnjs.cloudflare.com/ajax/libs/highlight.js/11.9.0/highlight.min.js"
+	highlightJSStylesheet = "https://cdnjs.cloudflare.com/ajax/libs/highlight.js/11.9.0/styles/github.min.css"
+)
+
+// htmlStyle is the inline stylesheet of HTML documents, so they display without any network access
+const htmlStyle = `
+body { margin: 0; font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; color: #1f2328; }
+nav { position: fixed; top: 0; bottom: 0; left: 0; width: 300px; overflow: auto; padding: 16px; box-sizing: border-box; background: #f6f8fa; border-right: 1px solid #d0d7de; font-size: 14px; }
+nav ul { list-style: none; margin: 0; padding-left: 16px; }
+nav > ul { padding-left: 0; }
+nav summary { cursor: pointer; }
+nav a { color: #0969da; text-decoration: none; }
+nav a:hover { text-decoration: underline; }
+main { margin-left: 300px; padding: 16px 32px; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #d0d7de; padding: 4px 12px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+section { margin-top: 32px; }
+h3 { font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; font-size: 15px; border-bottom: 1px solid #d0d7de; padding-bottom: 4px; }
+pre { background: #f6f8fa; padding: 12px; overflow: auto; font-size: 13px; line-height: 1.45; }
+`
+
+// htmlTreeNode is a directory or file of the sidebar file tree
+type htmlTreeNode struct {
+	name     string
+	anchor   string // Set for files
+	children map[string]*htmlTreeNode
+}
+
+// generateHTMLDocument creates a self-contained HTML page with a collapsible file tree that
+// links to every file, the statistics, the directory tree and the file contents. With
+// highlight set, the page loads highlight.js from a CDN to highlight the code blocks.
+func generateHTMLDocument(title, tree string, contents map[string]string, stats *RepoStats, overrides map[string]string, highlight bool) string {
+	var builder strings.Builder
+
+	// Get sorted keys for consistent output
+	keys := make([]string, 0, len(contents))
+	for k := range contents {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// Anchors are numbered, since paths may contain characters that are awkward in IDs
+	anchors := make(map[string]string, len(keys))
+	root := &htmlTreeNode{children: make(map[string]*htmlTreeNode)}
+	for i, path := range keys {
+		anchors[path] = fmt.Sprintf("file-%d", i+1)
+		root.add(strings.Split(path, "/"), anchors[path])
+	}
+
+	builder.WriteString("<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&builder, "<title>%s</title>\n", html.EscapeString(title))
+	builder.WriteString("<style>" + htmlStyle + "</style>\n")
+	if highlight {
+		fmt.Fprintf(&builder, "<link rel=\"stylesheet\" href=\"%s\">\n", highlightJSStylesheet)
+		fmt.Fprintf(&builder, "<script src=\"%s\"></script>\n<script>hljs.highlightAll();</script>\n", highlightJSScript)
+	}
+	builder.WriteString("</head>\n<body>\n")
+
+	// Add the file tree sidebar
+	builder.WriteString("<nav>\n<strong>Files</strong>\n")
+	root.write(&builder)
+	builder.WriteString("</nav>\n<main>\n")
+	fmt.Fprintf(&builder, "<h1>%s</h1>\n", html.EscapeString(title))
+
+	// Add statistics
+	if stats != nil {
+		writeHTMLStats(&builder, stats)
+	}
+
+	// Add directory tree
+	builder.WriteString("<h2>Directory Tree</h2>\n<pre>")
+	builder.WriteString(html.EscapeString(tree))
+	builder.WriteString("</pre>\n")
+
+	// Add each file as a code block
+	builder.WriteString("<h2>File Contents</h2>\n")
+	for _, path := range keys {
+		fmt.Fprintf(&builder, "<section id=\"%s\">\n<h3>%s</h3>\n", anchors[path], html.EscapeString(path))
+		if language := determineLanguage(path, overrides); language != "" {
+			fmt.Fprintf(&builder, "<pre><code class=\"language-%s\">", html.EscapeString(language))
+		} else {
+			builder.WriteString("<pre><code class=\"nohighlight\">")
+		}
+		builder.WriteString(html.EscapeString(contents[path]))
+		builder.WriteString("</code></pre>\n</section>\n")
+	}
+
+	builder.WriteString("</main>\n</body>\n</html>\n")
+	return builder.String()
+}
+
+// add inserts a file into the tree below the node
+func (n *htmlTreeNode) add(parts []string, anchor string) {
+	child, ok := n.children[parts[0]]
+	if !ok {
+		child = &htmlTreeNode{name: parts[0], children: make(map[string]*htmlTreeNode)}
+		n.children[parts[0]] = child
+	}
+	if len(parts) == 1 {
+		child.anchor = anchor
+		return
+	}
+	child.add(parts[1:], anchor)
+}
+
+// write writes the children of the node as a nested list, directories first. Directories
+// are collapsible and start expanded.
+func (n *htmlTreeNode) write(builder *strings.Builder) {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := n.children[names[i]], n.children[names[j]]
+		if (a.anchor == "") != (b.anchor == "") {
+			return a.anchor == ""
+		}
+		return names[i] < names[j]
+	})
+
+	builder.WriteString("<ul>\n")
+	for _, name := range names {
+		child := n.children[name]
+		if child.anchor != "" {
+			fmt.Fprintf(builder, "<li><a href=\"#%s\">%s</a></li>\n", child.anchor, html.EscapeString(name))
+			continue
+		}
+		fmt.Fprintf(builder, "<li><details open><summary>%s</summary>\n", html.EscapeString(name))
+		child.write(builder)
+		builder.WriteString("</details></li>\n")
+	}
+	builder.WriteString("</ul>\n")
+}
+
+// writeHTMLStats writes the per-language statistics as an HTML table, in the order of the
+// markdown statistics table
+func writeHTMLStats(builder *strings.Builder, stats *RepoStats) {
+	builder.WriteString("<h2>Statistics</h2>\n<table>\n<tr><th>Language</th><th>Files</th><th>Lines</th><th>Bytes</th></tr>\n")
+	for _, language := range stats.sortedLanguages() {
+		lang := stats.Languages[language]
+		fmt.Fprintf(builder, "<tr><td>%s</td><td>%d</td><td>%d</td><td>%d</td></tr>\n", html.EscapeString(language), lang.Files, lang.Lines, lang.Bytes)
+	}
+	fmt.Fprintf(builder, "<tr><th>Total</th><th>%d</th><th>%d</th><th>%d</th></tr>\n</table>\n", stats.TotalFiles, stats.TotalLines, stats.TotalBytes)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJobTTL      = time.Hour       // How long finished jobs are kept when JOB_TTL is not set
+	jobCleanupInterval = 5 * time.Minute // How often expired jobs are removed
+)
+
+// JobStatus describes the lifecycle state of an asynchronous analysis job
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// Job represents an asynchronous repository analysis
+type Job struct {
+	ID          string        `json:"id"`
+	Status      JobStatus     `json:"status"`
+	Error       string        `json:"error,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	DownloadURL string        `json:"download_url,omitempty"`
+	Result      *RepoResponse `json:"result,omitempty"`
+
+	request RepoRequest
+}
+
+// jobStore keeps job state in memory and expires finished jobs after a TTL
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+	ttl  time.Duration
+}
+
+// jobs holds every asynchronous job known to this instance, it is set up in main
+var jobs *jobStore
+
+// newJobStore creates an empty job store that expires finished jobs after ttl
+func newJobStore(ttl time.Duration) *jobStore {
+	return &jobStore{
+		jobs: make(map[string]*Job),
+		ttl:  ttl,
+	}
+}
+
+// jobTTL returns the retention time for finished jobs from the JOB_TTL env var
+func jobTTL() time.Duration {
+	if value := os.Getenv("JOB_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err == nil && ttl > 0 {
+			return ttl
+		}
+		log.Printf("Warning: Invalid JOB_TTL %q, using default of %v", value, defaultJobTTL)
+	}
+	return defaultJobTTL
+}
+
+// newJobID returns a random, unguessable job ID
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// create registers a new pending job for the request
+func (s *jobStore) create(req RepoRequest) (*Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:        id,
+		Status:    JobPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+		request:   req,
+	}
+
+	s.mu.Lock()
+	s.jobs[id] = job
+	s.mu.Unlock()
+
+	return job, nil
+}
+
+// get returns a snapshot of a job that is safe to use without holding the lock
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// update applies a change to a job while holding the lock
+func (s *jobStore) update(id string, change func(*Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if job, ok := s.jobs[id]; ok {
+		change(job)
+		job.UpdatedAt = time.Now()
+	}
+}
+
+// cleanup removes finished jobs that have not been updated within the TTL
+func (s *jobStore) cleanup(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, job := range s.jobs {
+		finished := job.Status == JobDone || job.Status == JobFailed
+		if finished && now.Sub(job.UpdatedAt) > s.ttl {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// startCleanup periodically removes expired jobs in a background goroutine
+func (s *jobStore) startCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			s.cleanup(now)
+		}
+	}()
+}
+
+// run executes the analysis of a job and records the outcome. The job stays pending until
+// wait, which the limiter returned on reserving its place, yields a free slot.
+func (s *jobStore) run(id string, req RepoRequest, wait func(context.Context) (func(), error)) {
+	// Jobs outlive the request that created them, so they wait for as long as it takes
+	release, err := wait(context.Background())
+	if err != nil {
+		s.fail(id, err)
+		return
+	}
+	defer release()
+
+	s.update(id, func(job *Job) { job.Status = JobRunning })
+	log.Printf("Job %s started for %s", id, req.RepoURL)
+
+	// The time limit starts once the job runs
+	ctx, cancel := context.WithTimeout(context.Background(), analyzeTimeout())
+	defer cancel()
+
+	resp, _, err := runAnalysis(ctx, &req)
+	if err != nil {
+		s.fail(id, err)
+		return
+	}
+
+	metrics.recordAnalysis("job", resp.Stats, nil)
+	s.update(id, func(job *Job) {
+		job.Status = JobDone
+		job.Result = resp
+		job.DownloadURL = "/jobs/" + id + "/result"
+	})
+	log.Printf("Job %s finished with %d files", id, len(resp.Contents))
+}
+
+// fail records the error of a job that could not be completed
+func (s *jobStore) fail(id string, err error) {
+	log.Printf("Job %s failed: %v", id, err)
+	metrics.recordAnalysis("job", nil, err)
+	s.update(id, func(job *Job) {
+		job.Status = JobFailed
+		job.Error = err.Error()
+	})
+}
+
+// handleCreateJob starts an asynchronous analysis and responds with the job ID
+func handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	// Parse request body
+	var req RepoRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error parsing request body: %v", err)
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	// Validate request before accepting the job so obvious mistakes fail fast
+	if err := validateRequest(&req); err != nil {
+		log.Printf("Invalid request: %v", err)
+		writeJSONError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	if r.URL.Query().Get("count_tokens") == "true" {
+		req.CountTokens = true
+	}
+	req.trusted = isTrustedCaller(r)
+
+	// Reserve the place of the job up front, so a full queue rejects it instead of piling up
+	// pending jobs
+	wait, err := limiter.reserve()
+	if err != nil {
+		writeLimiterError(w, err)
+		return
+	}
+
+	job, err := jobs.create(req)
+	if err != nil {
+		log.Printf("Failed to create job: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to create job: "+err.Error())
+		// Give the reserved place back, a cancelled context never waits for a slot
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if release, err := wait(ctx); err == nil {
+			release()
+		}
+		return
+	}
+
+	go jobs.run(job.ID, req, wait)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleGetJob returns the status of a job, including the result once it is done
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.get(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleGetJobResult downloads the result of a finished job in the requested format
+func handleGetJobResult(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.get(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+
+	switch job.Status {
+	case JobDone:
+	case JobFailed:
+		writeJSONError(w, http.StatusConflict, "Job failed: "+job.Error)
+		return
+	default:
+		writeJSONError(w, http.StatusConflict, "Job is not finished yet")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "markdown" // Default to markdown
+	}
+
+	// The stored request is shared with other downloads, so it is copied before changing it
+	req := job.request
+	req.highlight = r.URL.Query().Get("highlight") == "true"
+	writeResponse(w, &req, job.Result, format)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// jsonlMetadata is the first line of a JSON Lines response
+type jsonlMetadata struct {
+	Repository string `json:"repository"`
+	Tree       string `json:"tree"`
+}
+
+// jsonlSummary is the last line of a JSON Lines response. It is only written once every
+// file is, so a stream without it was cut off.
+type jsonlSummary struct {
+	FileCount int        `json:"file_count"` // Files included in the response
+	Stats     *RepoStats `json:"stats"`
+}
+
+// jsonlFile is a line of a JSON Lines response with the contents of one file
+type jsonlFile struct {
+	Path     string `json:"path"`
+	Language string `json:"language"`
+	Size     int    `json:"size"` // Bytes of content, after truncation and redaction
+	Content  string `json:"content"`
+	Outlined bool   `json:"outlined,omitempty"`
+}
+
+// setJSONLHeaders sets the headers of a JSON Lines download
+func setJSONLHeaders(w http.ResponseWriter, req *RepoRequest) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-analysis.jsonl", req.repoName()))
+}
+
+// newJSONLFile creates the line of a file
+func newJSONLFile(path, content string, outlined bool, overrides map[string]string) jsonlFile {
+	return jsonlFile{
+		Path:     path,
+		Language: determineLanguage(path, overrides),
+		Size:     len(content),
+		Content:  content,
+		Outlined: outlined,
+	}
+}
+
+// streamJSONL clones a repository and writes a metadata line followed by one JSON object
+// per file, flushing every line so clients can process files as they arrive, and a summary
+// line with the number of files written and their statistics. Errors before the response
+// is started are returned. It returns the statistics of the files written.
+func streamJSONL(ctx context.Context, w http.ResponseWriter, req *RepoRequest) (*RepoStats, error) {
+	repoID, repoDir, tree, candidates, skipped, err := prepareStream(ctx, req)
+	defer cleanupRepo(repoDir) // Clean up after processing
+	if err != nil {
+		return nil, err
+	}
+
+	setJSONLHeaders(w, req)
+	w.Header().Set(repoIDHeader, repoID)
+	flusher := http.NewResponseController(w)
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(jsonlMetadata{Repository: req.repoName(), Tree: tree}); err != nil {
+		log.Printf("Error: Failed to write JSON Lines response: %v", err)
+		return newRepoStats(), nil
+	}
+
+	var writeErr error
+	stats, readSkipped, _ := processCandidates(ctx, candidates, readWorkers(), req, func(file fileResult) {
+		if writeErr == nil {
+			writeErr = encoder.Encode(newJSONLFile(file.relPath, file.content, file.outlined, req.LanguageOverrides))
+			flusher.Flush()
+		}
+	})
+	metrics.recordSkipped(append(skipped, readSkipped...))
+	if writeErr != nil {
+		log.Printf("Error: Failed to write JSON Lines response: %v", writeErr)
+		return stats, nil
+	}
+	if ctx.Err() != nil {
+		// The response has already started, so the truncated stream can only be logged
+		log.Printf("Error: Streaming aborted after %d files: %v", stats.TotalFiles, ctx.Err())
+		return stats, nil
+	}
+	if err := encoder.Encode(jsonlSummary{FileCount: stats.TotalFiles, Stats: stats}); err != nil {
+		log.Printf("Error: Failed to write JSON Lines response: %v", err)
+		return stats, nil
+	}
+
+	if stats.TotalFiles == 0 {
+		log.Printf("Warning: No file contents were collected, %d files were skipped", len(skipped)+len(readSkipped))
+	}
+	return stats, nil
+}
+
+// writeJSONLResponse writes an already finished analysis as JSON Lines, in the same layout
+// as streamJSONL
+func writeJSONLResponse(w http.ResponseWriter, req *RepoRequest, resp *RepoResponse) {
+	setJSONLHeaders(w, req)
+
+	paths := make([]string, 0, len(resp.Contents))
+	for path := range resp.Contents {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	outlined := make(map[string]bool, len(resp.Outlined))
+	for _, path := range resp.Outlined {
+		outlined[path] = true
+	}
+
+	encoder := json.NewEncoder(w)
+	err := encoder.Encode(jsonlMetadata{Repository: req.repoName(), Tree: resp.Tree})
+	for _, path := range paths {
+		if err != nil {
+			break
+		}
+		err = encoder.Encode(newJSONLFile(path, resp.Contents[path], outlined[path], req.LanguageOverrides))
+	}
+	if err == nil {
+		err = encoder.Encode(jsonlSummary{FileCount: len(paths), Stats: resp.Stats})
+	}
+	if err != nil {
+		log.Printf("Error: Failed to write JSON Lines response: %v", err)
+	}
+}
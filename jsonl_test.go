@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// readJSONLSummary checks the lines of a JSON Lines response and returns its summary line
+// and the paths of its file lines
+func readJSONLSummary(t *testing.T, body string) (jsonlSummary, []string) {
+	t.Helper()
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(nil, 1<<20)
+
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) < 2 {
+		t.Fatalf("response has %d lines, want a metadata and a summary line:\n%s", len(lines), body)
+	}
+
+	var metadata jsonlMetadata
+	if err := json.Unmarshal([]byte(lines[0]), &metadata); err != nil || metadata.Tree == "" {
+		t.Fatalf("first line is not the metadata: %s (%v)", lines[0], err)
+	}
+
+	var paths []string
+	for _, line := range lines[1 : len(lines)-1] {
+		var file jsonlFile
+		if err := json.Unmarshal([]byte(line), &file); err != nil || file.Path == "" {
+			t.Fatalf("line is not a file: %s (%v)", line, err)
+		}
+		paths = append(paths, file.Path)
+	}
+
+	last := lines[len(lines)-1]
+	var summary jsonlSummary
+	if err := json.Unmarshal([]byte(last), &summary); err != nil || strings.Contains(last, `"path"`) {
+		t.Fatalf("last line is not the summary: %s (%v)", last, err)
+	}
+	return summary, paths
+}
+
+func TestStreamJSONLCountsWrittenFiles(t *testing.T) {
+	repoURL := serveTestRepo(t, newTestRepo(t, map[string]string{
+		"main.go":   "package main\n",
+		"README.md": "# Test\n",
+		"data.txt":  "binary\x00\x01\x02 content", // Selected for reading, then left out as binary
+	}))
+	chdirTemp(t)
+
+	oldCache := cache
+	cache = nil
+	defer func() { cache = oldCache }()
+
+	r := httptest.NewRequest(http.MethodGet, "/analyze?format=jsonl&repo_url="+url.QueryEscape(repoURL), nil)
+	w := httptest.NewRecorder()
+	handleAnalyzeRepo(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("analysis returned %d: %s", w.Code, w.Body.String())
+	}
+
+	summary, paths := readJSONLSummary(t, w.Body.String())
+	if summary.FileCount != 2 || len(paths) != 2 {
+		t.Errorf("summary counts %d files and %d were written, want 2 of each: %v", summary.FileCount, len(paths), paths)
+	}
+	if summary.Stats == nil || summary.Stats.TotalFiles != 2 {
+		t.Errorf("summary stats = %+v, want 2 files", summary.Stats)
+	}
+}
+
+func TestWriteJSONLResponseSummary(t *testing.T) {
+	stats := newRepoStats()
+	stats.TotalFiles = 2
+	resp := &RepoResponse{
+		Tree:     "project\n",
+		Contents: map[string]string{"b.go": "package b\n", "a.go": "package a\n"},
+		Stats:    stats,
+	}
+
+	w := httptest.NewRecorder()
+	writeJSONLResponse(w, &RepoRequest{RepoURL: "https://github.com/org/repo"}, resp)
+
+	summary, paths := readJSONLSummary(t, w.Body.String())
+	if summary.FileCount != 2 || strings.Join(paths, ",") != "a.go,b.go" {
+		t.Errorf("summary counts %d files and %v were written, want a.go and b.go", summary.FileCount, paths)
+	}
+}
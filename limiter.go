@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	defaultMaxConcurrentAnalyses = 4   // Analyses run at once when MAX_CONCURRENT_ANALYSES is not set
+	defaultAnalysisQueueSize     = 16  // Requests waiting for a free slot when ANALYSIS_QUEUE_SIZE is not set
+	analysisRetryAfter           = "5" // Seconds rejected clients are asked to wait before retrying
+)
+
+// limiter gates the analyses of the analyze, batch, upload, preview and job endpoints, it is
+// set up in main
+var limiter *analysisLimiter
+
+// analysisLimiter bounds the number of analyses that run at once. Requests beyond the limit
+// wait for a free slot while the queue has room and are rejected once it is full.
+type analysisLimiter struct {
+	slots     chan struct{}
+	queueSize int
+
+	mu     sync.Mutex
+	queued int
+}
+
+// newAnalysisLimiter creates a limiter that runs up to limit analyses at once and lets up to
+// queueSize requests wait for a slot
+func newAnalysisLimiter(limit, queueSize int) *analysisLimiter {
+	return &analysisLimiter{slots: make(chan struct{}, limit), queueSize: queueSize}
+}
+
+// maxConcurrentAnalyses returns the number of analyses that may run at once from the
+// MAX_CONCURRENT_ANALYSES env var
+func maxConcurrentAnalyses() int {
+	if value := os.Getenv("MAX_CONCURRENT_ANALYSES"); value != "" {
+		if limit, err := strconv.Atoi(value); err == nil && limit > 0 {
+			return limit
+		}
+		log.Printf("Warning: Invalid MAX_CONCURRENT_ANALYSES %q, using default of %d", value, defaultMaxConcurrentAnalyses)
+	}
+	return defaultMaxConcurrentAnalyses
+}
+
+// analysisQueueSize returns how many requests may wait for a free slot. ANALYSIS_QUEUE_MODE
+// selects between queueing up to ANALYSIS_QUEUE_SIZE requests and rejecting every request
+// beyond the limit right away.
+func analysisQueueSize() int {
+	switch mode := os.Getenv("ANALYSIS_QUEUE_MODE"); mode {
+	case "", "queue":
+	case "reject":
+		return 0
+	default:
+		log.Printf("Warning: Invalid ANALYSIS_QUEUE_MODE %q, queueing requests", mode)
+	}
+
+	if value := os.Getenv("ANALYSIS_QUEUE_SIZE"); value != "" {
+		if size, err := strconv.Atoi(value); err == nil && size >= 0 {
+			return size
+		}
+		log.Printf("Warning: Invalid ANALYSIS_QUEUE_SIZE %q, using default of %d", value, defaultAnalysisQueueSize)
+	}
+	return defaultAnalysisQueueSize
+}
+
+// acquire waits for a free slot and returns the function that releases it again. It fails
+// with 429 when the queue is full and with the error of the context when the request gives
+// up while waiting. Callers defer the release, so the slot is freed on panics as well.
+func (l *analysisLimiter) acquire(ctx context.Context) (func(), error) {
+	wait, err := l.reserve()
+	if err != nil {
+		return nil, err
+	}
+	return wait(ctx)
+}
+
+// reserve takes a free slot or a place in the queue without waiting, so callers that run the
+// analysis later can still reject the request right away. It fails with 429 when the queue
+// is full. The returned function must be called exactly once, it waits for the slot like
+// acquire.
+func (l *analysisLimiter) reserve() (func(context.Context) (func(), error), error) {
+	if l == nil {
+		return func(context.Context) (func(), error) { return func() {}, nil }, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return func(context.Context) (func(), error) { return l.release, nil }, nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.queued >= l.queueSize {
+		l.mu.Unlock()
+		return nil, &requestError{http.StatusTooManyRequests, "Too many analyses in progress, try again later"}
+	}
+	l.queued++
+	l.mu.Unlock()
+
+	return func(ctx context.Context) (func(), error) {
+		defer func() {
+			l.mu.Lock()
+			l.queued--
+			l.mu.Unlock()
+		}()
+
+		select {
+		case l.slots <- struct{}{}:
+			return l.release, nil
+		case <-ctx.Done():
+			return nil, contextError(ctx)
+		}
+	}, nil
+}
+
+// release frees a slot taken by acquire
+func (l *analysisLimiter) release() {
+	<-l.slots
+}
+
+// acquireAnalysisSlot takes a slot for the analysis of a request and writes the error
+// response when none is available
+func acquireAnalysisSlot(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	release, err := limiter.acquire(r.Context())
+	if err != nil {
+		writeLimiterError(w, err)
+		return nil, false
+	}
+	return release, true
+}
+
+// writeLimiterError writes the error response of a request the limiter did not admit
+func writeLimiterError(w http.ResponseWriter, err error) {
+	log.Printf("Not analyzing request: %v", err)
+	if errorStatus(err) == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", analysisRetryAfter)
+	}
+	writeJSONError(w, errorStatus(err), err.Error())
+}
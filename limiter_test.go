@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAnalysisLimiterReserve(t *testing.T) {
+	l := newAnalysisLimiter(1, 1)
+
+	running, err := l.reserve()
+	if err != nil {
+		t.Fatalf("first reservation failed: %v", err)
+	}
+	queued, err := l.reserve()
+	if err != nil {
+		t.Fatalf("queued reservation failed: %v", err)
+	}
+	if _, err := l.reserve(); errorStatus(err) != http.StatusTooManyRequests {
+		t.Fatalf("reservation beyond the queue got %v, want 429", err)
+	}
+
+	release, err := running(context.Background())
+	if err != nil {
+		t.Fatalf("waiting for a free slot failed: %v", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		release, err := queued(context.Background())
+		if err != nil {
+			t.Errorf("waiting in the queue failed: %v", err)
+		}
+		acquired <- release
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("queued reservation got a slot while all slots were busy")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case release := <-acquired:
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("queued reservation did not get the released slot")
+	}
+}
+
+func TestAnalysisLimiterAcquireCancelled(t *testing.T) {
+	l := newAnalysisLimiter(1, 1)
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.acquire(ctx); errorStatus(err) != http.StatusServiceUnavailable {
+		t.Errorf("cancelled acquire got %v, want 503", err)
+	}
+
+	// The cancelled request left the queue again
+	wait, err := l.reserve()
+	if err != nil {
+		t.Fatalf("queue was not freed: %v", err)
+	}
+	if _, err := wait(ctx); err == nil {
+		t.Error("cancelled wait got a busy slot")
+	}
+}
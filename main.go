@@ -1,17 +1,30 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/joho/godotenv"
 )
@@ -19,15 +32,110 @@ import (
 // DirRequest represents a directory or file to be processed
 type DirRequest struct {
 	Path      string `json:"path"`
-	Recursive bool   `json:"recursive,omitempty"`
+	Recursive *bool  `json:"recursive,omitempty"`
+	MaxDepth  int    `json:"max_depth,omitempty"` // Directory levels walked below Path, 0 means no limit
 	Exclude   bool   `json:"exclude,omitempty"`
 }
 
+// isRecursive reports whether subdirectories should be walked, which is the default when unset
+func (d DirRequest) isRecursive() bool {
+	return d.Recursive == nil || *d.Recursive
+}
+
 // RepoRequest represents the request payload for cloning a repository
 type RepoRequest struct {
 	RepoURL   string       `json:"repo_url"`
+	LocalPath string       `json:"local_path,omitempty"`
 	IsPrivate bool         `json:"is_private"`
 	Dirs      []DirRequest `json:"dirs,omitempty"`
+	Branch    string       `json:"branch,omitempty"`
+	Tag       string       `json:"tag,omitempty"`
+	Commit    string       `json:"commit,omitempty"`
+	Depth     *int         `json:"depth,omitempty"`
+	Auth      *AuthRequest `json:"auth,omitempty"`
+
+	MaxFileSize            int64    `json:"max_file_size,omitempty"`
+	MaxFileLines           int      `json:"max_file_lines,omitempty"`
+	MaxFileBytes           int      `json:"max_file_bytes,omitempty"`
+	MaxTotalBytes          int      `json:"max_total_bytes,omitempty"`
+	Outline                bool     `json:"outline,omitempty"` // Include only the declarations of supported languages
+	ExtraExcludeExtensions []string `json:"extra_exclude_extensions,omitempty"`
+	ForceIncludeExtensions []string `json:"force_include_extensions,omitempty"`
+	IncludeIgnored         bool     `json:"include_ignored,omitempty"`
+	CountTokens            bool     `json:"count_tokens,omitempty"`
+	MaxRepoSizeKB          int64    `json:"max_repo_size_kb,omitempty"`
+	DryRun                 bool     `json:"dry_run,omitempty"`
+	Submodules             bool     `json:"submodules,omitempty"`
+	DiffBase               string   `json:"diff_base,omitempty"`       // Only analyze files changed since this ref
+	Redact                 bool     `json:"redact,omitempty"`          // Redact built-in secret patterns and skip sensitive files
+	RedactPatterns         []string `json:"redact_patterns,omitempty"` // Additional regular expressions to redact
+	SkipFiles              []string `json:"skip_files,omitempty"`      // File name patterns to skip entirely
+
+	LanguageOverrides map[string]string `json:"language_overrides,omitempty"` // Extension or glob to language
+
+	trusted    bool         // Set for trusted callers, which may raise limits
+	sourceName string       // Name of an uploaded archive, used instead of the repository name
+	highlight  bool         // Load highlight.js in HTML documents
+	changes    []FileChange // Files changed since DiffBase, set when the repository is cloned
+}
+
+// repoName returns the name used for downloads of the analysis of a request
+func (req *RepoRequest) repoName() string {
+	switch {
+	case req.sourceName != "":
+		return req.sourceName
+	case req.LocalPath != "":
+		return filepath.Base(req.LocalPath)
+	default:
+		return extractRepoName(req.RepoURL)
+	}
+}
+
+// SkipReason explains why a file was left out of the analysis
+type SkipReason string
+
+const (
+	SkipBinary      SkipReason = "binary"
+	SkipTooLarge    SkipReason = "too_large"
+	SkipExcluded    SkipReason = "excluded"
+	SkipGitignored  SkipReason = "gitignored"
+	SkipDumpignored SkipReason = "dumpignored"
+	SkipReadError   SkipReason = "read_error"
+	SkipSensitive   SkipReason = "sensitive"
+	SkipBudget      SkipReason = "budget_exceeded"
+	SkipNotRegular  SkipReason = "not_regular"
+)
+
+// SkippedFile records a file or directory that was not included in the analysis
+type SkippedFile struct {
+	Path   string     `json:"path"`
+	Reason SkipReason `json:"reason"`
+	Error  string     `json:"error,omitempty"` // Cause of a read_error, such as "permission denied"
+}
+
+// readErrorEntry records a file or directory that could not be read. Only the cause of the
+// error is kept, since the full message includes the location of the clone on the server.
+func readErrorEntry(relPath string, err error) SkippedFile {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return SkippedFile{Path: relPath, Reason: SkipReadError, Error: err.Error()}
+}
+
+// LanguageStats aggregates the size of the files of a single language
+type LanguageStats struct {
+	Files int   `json:"files"`
+	Lines int   `json:"lines"`
+	Bytes int64 `json:"bytes"`
+}
+
+// RepoStats summarizes the collected files, overall and per language
+type RepoStats struct {
+	TotalFiles int                       `json:"total_files"`
+	TotalLines int                       `json:"total_lines"`
+	TotalBytes int64                     `json:"total_bytes"`
+	Languages  map[string]*LanguageStats `json:"languages"`
 }
 
 // RepoResponse represents the response with file content and directory tree
@@ -35,12 +143,62 @@ type RepoResponse struct {
 	Tree     string            `json:"tree"`
 	Contents map[string]string `json:"contents"`
 	Markdown string            `json:"markdown"`
+	Skipped  []SkippedFile     `json:"skipped"`
+	Stats    *RepoStats        `json:"stats"`
+
+	TokenCount int            `json:"token_count,omitempty"`
+	FileTokens map[string]int `json:"file_tokens,omitempty"`
+
+	DryRun bool     `json:"dry_run,omitempty"`
+	Files  []string `json:"files,omitempty"` // Paths that would be included, only set for dry runs
+
+	Changes []FileChange `json:"changes,omitempty"` // Files changed since diff_base, only set for diffs
+
+	Outlined []string `json:"outlined,omitempty"` // Files reduced to their declarations, only set in outline mode
+
+	Capped       bool `json:"capped,omitempty"`        // Files were left out to stay within max_total_bytes
+	OmittedFiles int  `json:"omitted_files,omitempty"` // Number of files left out because of max_total_bytes
+
+	RepoID string `json:"repo_id,omitempty"` // ID of the analysis, which names its files in the output directory
 }
 
+// repoIDHeader carries the ID of an analysis, so callers can find its saved output files
+const repoIDHeader = "X-Repo-ID"
+
 const (
 	tempDir     = "./temp_repos"
 	outputDir   = "./output"
 	maxFileSize = 10 * 1024 * 1024 // 10MB limit for file content
+
+	dumpIgnoreFile  = ".dumpignore"  // Exclusions of the repository owner, in gitignore syntax
+	dumpIncludeFile = ".dumpinclude" // Allow-list of the repository owner, in gitignore syntax
+
+	defaultCloneDepth = 1 // Shallow clone by default since only the working tree is analyzed
+
+	defaultAnalyzeTimeout = 5 * time.Minute // Time limit for a single analysis when ANALYZE_TIMEOUT is not set
+)
+
+var (
+	// refPattern restricts branch and tag names to characters that are safe to pass to git
+	refPattern = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+	// commitPattern matches abbreviated or full commit SHAs
+	commitPattern = regexp.MustCompile(`^[0-9a-fA-F]{4,40}$`)
+
+	// languagePattern restricts language overrides to names that are safe in code fences
+	languagePattern = regexp.MustCompile(`^[A-Za-z0-9_+#.-]+$`)
+
+	// scpURLPattern matches scp-style SSH URLs such as git@github.com:org/repo.git
+	scpURLPattern = regexp.MustCompile(`^([A-Za-z0-9._-]+)@([A-Za-z0-9.-]+):([A-Za-z0-9._~/-]+)$`)
+
+	// defaultAllowedHosts lists the git hosts accepted when ALLOWED_GIT_HOSTS is not set
+	defaultAllowedHosts = []string{"github.com", "gitlab.com", "bitbucket.org"}
+
+	// errRefNotFound is returned when the requested branch, tag or commit does not exist
+	errRefNotFound = errors.New("reference not found")
+
+	// errInvalidSubmodule is returned when a submodule points to a path or host that is not allowed
+	errInvalidSubmodule = errors.New("invalid submodule")
 )
 
 func main() {
@@ -62,9 +220,48 @@ func main() {
 	}
 	log.Println("Temporary directories created successfully")
 
-	// Set up HTTP handlers with logging middleware
-	http.HandleFunc("/analyze", loggingMiddleware(handleAnalyzeRepo))
+	// Require an API key on every endpoint except /health when keys are configured
+	apiKeys = loadAPIKeys()
+	if len(apiKeys) > 0 {
+		log.Printf("API key authentication enabled with %s", pluralize(len(apiKeys), "key", "keys"))
+	}
+
+	// Set up HTTP handlers with logging middleware, responses with analyses are compressed for
+	// clients that accept gzip
+	http.HandleFunc("/analyze", loggingMiddleware(apiKeyMiddleware(gzipMiddleware(handleAnalyzeRepo))))
+	http.HandleFunc("POST /analyze/batch", loggingMiddleware(apiKeyMiddleware(gzipMiddleware(handleAnalyzeBatch))))
+	http.HandleFunc("POST /analyze/upload", loggingMiddleware(apiKeyMiddleware(gzipMiddleware(handleAnalyzeUpload))))
+	http.HandleFunc("/preview", loggingMiddleware(apiKeyMiddleware(gzipMiddleware(handlePreview))))
 	http.HandleFunc("/health", loggingMiddleware(handleHealthCheck))
+	http.HandleFunc("POST /jobs", loggingMiddleware(apiKeyMiddleware(handleCreateJob)))
+	http.HandleFunc("GET /jobs/{id}", loggingMiddleware(apiKeyMiddleware(gzipMiddleware(handleGetJob))))
+	http.HandleFunc("GET /jobs/{id}/result", loggingMiddleware(apiKeyMiddleware(gzipMiddleware(handleGetJobResult))))
+
+	// Keep job state in memory and remove finished jobs once they expire
+	jobs = newJobStore(jobTTL())
+	jobs.startCleanup(jobCleanupInterval)
+
+	// Bound the number of analyses running at once
+	limiter = newAnalysisLimiter(maxConcurrentAnalyses(), analysisQueueSize())
+
+	// Cache analyses of unchanged revisions unless disabled
+	if cacheEnabled() {
+		cache = newAnalysisCache(cacheSize(), cacheTTL())
+	}
+
+	// Metrics are only collected and served on request
+	if metricsEnabled() {
+		metrics = newMetricsRegistry()
+		http.HandleFunc("GET /metrics", apiKeyMiddleware(metrics.handler()))
+		log.Println("Serving Prometheus metrics at /metrics")
+	}
+
+	// Output files are only written on request and removed again once they expire
+	if persistOutput() {
+		outputs = newOutputStore(outputDir, outputTTL())
+		outputs.startCleanup(outputCleanupInterval)
+		log.Printf("Persisting output files to %s", outputDir)
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -88,6 +285,7 @@ func loggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		duration := time.Since(startTime)
 		log.Printf("Request completed: %s %s - Status: %d - Duration: %v",
 			r.Method, r.URL.Path, rw.statusCode, duration)
+		metrics.recordRequest(r, rw.statusCode, duration)
 	}
 }
 
@@ -103,6 +301,11 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap returns the wrapped writer, so http.ResponseController can flush streamed responses
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // handleHealthCheck provides a simple health check endpoint
 func handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -121,25 +324,95 @@ func createDirectories() error {
 	return nil
 }
 
-// handleAnalyzeRepo handles the HTTP request to analyze a GitHub repository
-func handleAnalyzeRepo(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// requestError is an error that is reported to the client with a specific HTTP status code
+type requestError struct {
+	status  int
+	message string
+}
+
+// Error returns the message shown to the client
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// errorStatus returns the HTTP status code for an error returned by the analysis pipeline
+func errorStatus(err error) int {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return reqErr.status
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorResponse is the JSON body of every error response
+type ErrorResponse struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+// writeJSONError writes an error response as JSON. Errors of download formats such as
+// markdown are JSON as well, since the error is not the downloaded document.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Del("Content-Disposition")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message, Status: status})
+}
+
+// analyzeTimeout returns the time limit for cloning and analyzing a repository from the
+// ANALYZE_TIMEOUT env var
+func analyzeTimeout() time.Duration {
+	if value := os.Getenv("ANALYZE_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err == nil && timeout > 0 {
+			return timeout
+		}
+		log.Printf("Warning: Invalid ANALYZE_TIMEOUT %q, using default of %v", value, defaultAnalyzeTimeout)
 	}
+	return defaultAnalyzeTimeout
+}
+
+// contextError returns the request error for an analysis whose context is done, which
+// is a gateway timeout if the time limit was exceeded
+func contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &requestError{http.StatusGatewayTimeout, "Analysis timed out"}
+	}
+	return &requestError{http.StatusServiceUnavailable, "Analysis cancelled"}
+}
 
-	// Parse request body
+// handleAnalyzeRepo handles the HTTP request to analyze a GitHub repository
+func handleAnalyzeRepo(w http.ResponseWriter, r *http.Request) {
 	var req RepoRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("Error parsing request body: %v", err)
-		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+
+	switch r.Method {
+	case http.MethodPost:
+		// Parse request body
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Printf("Error parsing request body: %v", err)
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+
+	case http.MethodGet:
+		// Build the request from query parameters for simple integrations
+		var err error
+		if req, err = requestFromQuery(r.URL.Query()); err != nil {
+			log.Printf("Invalid query parameters: %v", err)
+			writeJSONError(w, errorStatus(err), err.Error())
+			return
+		}
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Validate request
-	if req.RepoURL == "" {
-		log.Printf("Invalid request: missing repository URL")
-		http.Error(w, "Repository URL is required", http.StatusBadRequest)
+	if err := validateRequest(&req); err != nil {
+		log.Printf("Invalid request: %v", err)
+		writeJSONError(w, errorStatus(err), err.Error())
 		return
 	}
 
@@ -150,310 +423,1372 @@ func handleAnalyzeRepo(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("Requested response format: %s", format)
 
-	// Create a unique directory for this repository
-	repoID := fmt.Sprintf("%d", time.Now().UnixNano())
-	repoDir := filepath.Join(tempDir, repoID)
-	defer cleanupRepo(repoDir) // Clean up after processing
+	// Token counting is extra work, so it is only done on request
+	if r.URL.Query().Get("count_tokens") == "true" {
+		req.CountTokens = true
+	}
+	if r.URL.Query().Get("dry_run") == "true" {
+		req.DryRun = true
+	}
+	req.trusted = isTrustedCaller(r)
+	req.highlight = r.URL.Query().Get("highlight") == "true"
 
-	// Clone the repository
-	log.Printf("Cloning repository: %s", req.RepoURL)
-	if err := cloneRepo(req.RepoURL, repoDir, req.IsPrivate); err != nil {
-		log.Printf("Failed to clone repository: %v", err)
-		http.Error(w, "Failed to clone repository: "+err.Error(), http.StatusInternalServerError)
+	// Wait for a free slot so concurrent requests cannot start unlimited clones
+	release, ok := acquireAnalysisSlot(w, r)
+	if !ok {
 		return
 	}
+	defer release()
+
+	// Give up on clones and analyses that take too long or whose client went away
+	ctx, cancel := context.WithTimeout(r.Context(), analyzeTimeout())
+	defer cancel()
+
+	// Zip archives, markdown and text are streamed so large repositories are never held in
+	// memory at once. They are still served from the cache, so retries of an unchanged
+	// revision are not cloned again.
+	streamed := (format == "zip" || isStreamedFormat(format)) && !req.DryRun
+	var cacheKey string
+	if streamed {
+		cacheKey = cache.key(ctx, &req)
+		if resp, ok := cache.getStreamed(cacheKey, format); ok {
+			log.Printf("Serving cached analysis of %s", req.RepoURL)
+			metrics.recordAnalysis(format, resp.Stats, nil)
+			writeResponse(w, &req, resp, format)
+			return
+		}
+	}
 
-	// Generate repository analysis
-	log.Printf("Analyzing repository...")
-	resp, err := analyzeRepo(repoDir, req.Dirs)
-	if err != nil {
-		log.Printf("Failed to analyze repository: %v", err)
-		http.Error(w, "Failed to analyze repository: "+err.Error(), http.StatusInternalServerError)
+	if streamed && format == "zip" {
+		stats, err := streamZip(ctx, w, &req)
+		metrics.recordAnalysis(format, stats, err)
+		if err != nil {
+			log.Printf("Analysis failed: %v", err)
+			writeJSONError(w, errorStatus(err), err.Error())
+			return
+		}
+		log.Printf("Zip archive streamed successfully with %d files", stats.TotalFiles)
 		return
 	}
-
-	// Check if we have any file contents
-	if len(resp.Contents) == 0 {
-		log.Printf("Warning: No file contents were collected, possibly due to exclusion rules")
+	if streamed && format == "jsonl" {
+		stats, err := streamJSONL(ctx, w, &req)
+		metrics.recordAnalysis(format, stats, err)
+		if err != nil {
+			log.Printf("Analysis failed: %v", err)
+			writeJSONError(w, errorStatus(err), err.Error())
+			return
+		}
+		log.Printf("JSON Lines streamed successfully with %d files", stats.TotalFiles)
+		return
 	}
-
-	// Save output to a file
-	if err := saveOutputToFile(repoID, resp); err != nil {
-		log.Printf("Warning: Failed to save output to file: %v", err)
+	if streamed {
+		stats, err := streamAnalysis(ctx, w, &req, format, cacheKey)
+		metrics.recordAnalysis(format, stats, err)
+		if err != nil {
+			log.Printf("Analysis failed: %v", err)
+			writeJSONError(w, errorStatus(err), err.Error())
+			return
+		}
+		log.Printf("Response streamed successfully with %d files", stats.TotalFiles)
+		return
 	}
 
-	// Return the response based on requested format
-	switch format {
-	case "json":
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(resp)
-
-	case "text", "txt":
-		w.Header().Set("Content-Type", "text/plain")
-		repoName := extractRepoName(req.RepoURL)
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-analysis.txt", repoName))
-
-		// First add the directory tree
-		w.Write([]byte("# Directory Tree\n\n" + resp.Tree + "\n\n"))
-		// Then add the markdown content without the initial heading
-		markdownWithoutHeader := strings.Replace(resp.Markdown, "# Repository Analysis\n\n", "# File Contents\n\n", 1)
-		w.Write([]byte(markdownWithoutHeader))
-
-	default: // markdown or any other value defaults to markdown
-		w.Header().Set("Content-Type", "text/markdown")
-		repoName := extractRepoName(req.RepoURL)
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-analysis.md", repoName))
-		w.Write([]byte(resp.Markdown))
+	resp, _, err := runAnalysis(ctx, &req)
+	if err != nil {
+		log.Printf("Analysis failed: %v", err)
+		metrics.recordAnalysis(format, nil, err)
+		writeJSONError(w, errorStatus(err), err.Error())
+		return
 	}
+	metrics.recordAnalysis(format, resp.Stats, nil)
 
+	writeResponse(w, &req, resp, format)
 	log.Printf("Response sent successfully with %d files", len(resp.Contents))
 }
 
-// extractRepoName extracts a repository name from its URL
-func extractRepoName(repoURL string) string {
-	// Remove trailing .git if present
-	repoURL = strings.TrimSuffix(repoURL, ".git")
-
-	// Extract the last part of the URL path
-	parts := strings.Split(repoURL, "/")
-	if len(parts) > 0 {
-		return parts[len(parts)-1]
+// requestFromQuery builds a request from the query parameters of a GET /analyze call.
+// Include and exclude dirs are given as comma-separated lists.
+func requestFromQuery(query url.Values) (RepoRequest, error) {
+	req := RepoRequest{
+		RepoURL:   query.Get("repo_url"),
+		IsPrivate: query.Get("is_private") == "true",
+		Branch:    query.Get("branch"),
+		Tag:       query.Get("tag"),
+		Commit:    query.Get("commit"),
 	}
 
-	return "repo"
-}
+	// GET requests are easy to trigger from anywhere, so only allow private
+	// repositories when the service is configured with a token for them
+	if req.IsPrivate && os.Getenv("GITHUB_TOKEN") == "" {
+		return req, &requestError{http.StatusBadRequest, "Private repositories require GITHUB_TOKEN to be configured"}
+	}
 
-// cloneRepo clones a GitHub repository to the specified directory
-func cloneRepo(repoURL, repoDir string, isPrivate bool) error {
-	log.Printf("Cloning repository %s to %s", repoURL, repoDir)
+	if value := query.Get("depth"); value != "" {
+		depth, err := strconv.Atoi(value)
+		if err != nil {
+			return req, &requestError{http.StatusBadRequest, "Invalid depth: " + value}
+		}
+		req.Depth = &depth
+	}
 
-	// Construct git clone command
-	// Use --config core.autocrlf=input to normalize line endings
-	cmd := exec.Command("git", "clone", "--config", "core.autocrlf=input", repoURL, repoDir)
+	// Directories are walked recursively unless recursive=false is given
+	recursive := query.Get("recursive") != "false"
 
-	// If it's a private repository, set up authentication
-	if isPrivate {
-		githubToken := os.Getenv("GITHUB_TOKEN")
-		if githubToken == "" {
-			return fmt.Errorf("GITHUB_TOKEN environment variable not set for private repository")
+	maxDepth := 0
+	if value := query.Get("max_depth"); value != "" {
+		var err error
+		if maxDepth, err = strconv.Atoi(value); err != nil {
+			return req, &requestError{http.StatusBadRequest, "Invalid max_depth: " + value}
 		}
-
-		// Format URL with token for authentication
-		parsedURL := strings.Replace(repoURL, "https://", fmt.Sprintf("https://%s@", githubToken), 1)
-		cmd = exec.Command("git", "clone", "--config", "core.autocrlf=input", parsedURL, repoDir)
 	}
 
-	// Execute the command
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("git clone failed: %v - %s", err, string(output))
+	includes := splitQueryList(query.Get("include"))
+	if len(includes) == 0 && (!recursive || maxDepth != 0) {
+		// Without an include the options apply to the root, or to the directory a browser
+		// URL points to
+		root := "."
+		if location := normalizeRepoURL(req.RepoURL); location.path != "" {
+			root = location.path
+		}
+		includes = []string{root}
 	}
-
-	return nil
-}
-
-// analyzeRepo analyzes a repository and returns its directory tree and file contents
-func analyzeRepo(repoDir string, dirRequests []DirRequest) (*RepoResponse, error) {
-	// Generate directory tree
-	tree, err := generateDirectoryTree(repoDir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate directory tree: %v", err)
+	for _, path := range includes {
+		req.Dirs = append(req.Dirs, DirRequest{Path: path, Recursive: &recursive, MaxDepth: maxDepth})
 	}
-
-	// Extract file contents
-	contents, err := extractFileContents(repoDir, dirRequests)
-	if err != nil {
-		return nil, fmt.Errorf("failed to extract file contents: %v", err)
+	for _, path := range splitQueryList(query.Get("exclude")) {
+		req.Dirs = append(req.Dirs, DirRequest{Path: path, Exclude: true})
 	}
 
-	// Generate markdown document with tree included
-	markdown := generateMarkdownDocument(tree, contents)
+	return req, nil
+}
 
-	return &RepoResponse{
-		Tree:     tree,
-		Contents: contents,
-		Markdown: markdown,
-	}, nil
+// splitQueryList splits a comma-separated query parameter, dropping empty entries
+func splitQueryList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
-// generateMarkdownDocument creates a markdown document with directory tree and all file contents
-func generateMarkdownDocument(tree string, contents map[string]string) string {
-	var builder strings.Builder
+// validateRequest checks that a request is complete and safe to pass to git. Browser URLs
+// of repositories are normalized to the URL to clone on the way.
+func validateRequest(req *RepoRequest) error {
+	if req.LocalPath != "" {
+		if err := validateLocalPath(req); err != nil {
+			return err
+		}
+	} else {
+		if req.RepoURL == "" {
+			return &requestError{http.StatusBadRequest, "Repository URL is required"}
+		}
 
-	// Add title
-	builder.WriteString("# Repository Analysis\n\n")
+		// Accept URLs copied from the browser, such as .../tree/main/src
+		applyRepoURL(req)
 
-	// Add directory tree
-	builder.WriteString("## Directory Tree\n\n```\n")
-	builder.WriteString(tree)
-	builder.WriteString("\n```\n\n")
+		if err := validateRepoURL(req.RepoURL); err != nil {
+			return &requestError{http.StatusBadRequest, "Invalid repository URL: " + err.Error()}
+		}
+	}
 
-	// Add file contents section
-	builder.WriteString("## File Contents\n\n")
+	if err := validateRefs(req); err != nil {
+		return &requestError{http.StatusBadRequest, "Invalid request: " + err.Error()}
+	}
 
-	// Get sorted keys for consistent output
-	keys := make([]string, 0, len(contents))
-	for k := range contents {
-		keys = append(keys, k)
+	if err := validateAuth(req); err != nil {
+		return &requestError{http.StatusBadRequest, "Invalid auth: " + err.Error()}
 	}
-	sort.Strings(keys)
 
-	// Add each file with markdown formatting
-	for _, path := range keys {
-		content := contents[path]
+	if err := validateLanguageOverrides(req.LanguageOverrides); err != nil {
+		return &requestError{http.StatusBadRequest, "Invalid language_overrides: " + err.Error()}
+	}
+
+	if err := validateContentFilters(req); err != nil {
+		return &requestError{http.StatusBadRequest, "Invalid request: " + err.Error()}
+	}
 
-		// Add file header with horizontal rule
-		builder.WriteString("---\n\n")
-		builder.WriteString(fmt.Sprintf("### %s\n\n", path))
+	if err := validateDirs(req.Dirs); err != nil {
+		return &requestError{http.StatusBadRequest, "Invalid dirs: " + err.Error()}
+	}
 
-		// Determine language for syntax highlighting
-		language := determineLanguage(path)
+	return nil
+}
 
-		// Add content with code fence
-		if language != "" {
-			builder.WriteString(fmt.Sprintf("```%s\n%s\n```\n\n", language, content))
-		} else {
-			builder.WriteString(fmt.Sprintf("```\n%s\n```\n\n", content))
+// validateDirs rejects include and exclude paths that are absolute or leave the repository
+// through "..", since they are joined to the repository directory
+func validateDirs(dirs []DirRequest) error {
+	for _, dir := range dirs {
+		clean := path.Clean(filepath.ToSlash(dir.Path))
+		if path.IsAbs(clean) || filepath.IsAbs(dir.Path) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("path %q must be relative to the repository root", dir.Path)
+		}
+		if err := validatePatternSize(dir.Path); err != nil {
+			return err
 		}
 	}
-
-	return builder.String()
+	return nil
 }
 
-// determineLanguage determines the language for syntax highlighting based on file extension
-func determineLanguage(path string) string {
-	extension := strings.ToLower(filepath.Ext(path))
+// newRepoID returns a unique ID for the working directory and output files of a request. It
+// starts with a hash of the repository and ref, which is the same for every analysis of a
+// revision, followed by a random suffix that tells the analyses apart.
+func newRepoID(req *RepoRequest) (string, error) {
+	source := req.RepoURL
+	if source == "" {
+		source = req.LocalPath + req.sourceName
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{source, req.Branch, req.Tag, req.Commit}, "\x00")))
 
-	// Common file extensions mapped to their languages
-	extensionMap := map[string]string{
-		".go":    "go",
-		".js":    "javascript",
-		".py":    "python",
-		".java":  "java",
-		".sh":    "bash",
-		".md":    "markdown",
-		".html":  "html",
-		".css":   "css",
-		".json":  "json",
-		".yaml":  "yaml",
-		".yml":   "yaml",
-		".xml":   "xml",
-		".sql":   "sql",
-		".c":     "c",
-		".cpp":   "cpp",
-		".h":     "c",
-		".ts":    "typescript",
-		".rb":    "ruby",
-		".php":   "php",
-		".rs":    "rust",
-		".swift": "swift",
-		".kt":    "kotlin",
-	}
-
-	if language, ok := extensionMap[extension]; ok {
-		return language
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(sum[:6]) + "-" + hex.EncodeToString(suffix), nil
+}
 
-	return ""
+// createRepoDir creates the working directory of a request in tempDir. An existing directory
+// is never reused, so concurrent requests cannot end up sharing one.
+func createRepoDir(req *RepoRequest) (string, string, error) {
+	repoID, err := newRepoID(req)
+	if err != nil {
+		return "", "", &requestError{http.StatusInternalServerError, "Failed to create repository ID: " + err.Error()}
+	}
+	repoDir := filepath.Join(tempDir, repoID)
+	if err := os.Mkdir(repoDir, 0755); err != nil {
+		return "", "", &requestError{http.StatusInternalServerError, "Failed to create working directory: " + err.Error()}
+	}
+	return repoID, repoDir, nil
 }
 
-// generateDirectoryTree generates a text representation of the repository directory structure
-func generateDirectoryTree(repoDir string) (string, error) {
-	log.Printf("Generating directory tree for %s", repoDir)
+// runAnalysis clones and analyzes the repository of a validated request, returning the
+// analysis together with the ID under which its output files were saved
+func runAnalysis(ctx context.Context, req *RepoRequest) (*RepoResponse, string, error) {
+	// Serve repeated requests for an unchanged revision without cloning again
+	cacheKey := cache.key(ctx, req)
+	if resp, ok := cache.get(cacheKey); ok {
+		log.Printf("Serving cached analysis of %s", req.RepoURL)
+		return resp, "", nil
+	}
 
-	// Use the external 'tree' command or a custom implementation
-	cmd := exec.Command("tree", "-a", "-I", ".git", "--gitignore", repoDir)
-	output, err := cmd.CombinedOutput()
+	repoID, repoDir, err := checkoutRepo(ctx, req)
+	defer cleanupRepo(repoDir) // Clean up after processing
+	if err != nil {
+		return nil, repoID, err
+	}
 
-	// If 'tree' command is not available, use a custom implementation
+	// Generate repository analysis
+	log.Printf("Analyzing repository...")
+	resp, err := analyzeRepo(ctx, repoDir, req)
 	if err != nil {
-		log.Println("External 'tree' command failed, using custom implementation")
-		return generateCustomDirectoryTree(repoDir)
+		log.Printf("Failed to analyze repository: %v", err)
+		if ctx.Err() != nil {
+			return nil, repoID, contextError(ctx)
+		}
+		return nil, repoID, &requestError{http.StatusInternalServerError, "Failed to analyze repository: " + err.Error()}
 	}
 
-	return string(output), nil
-}
+	resp.RepoID = repoID
 
-// generateCustomDirectoryTree creates a directory tree structure without external dependencies
-func generateCustomDirectoryTree(rootDir string) (string, error) {
-	var builder strings.Builder
-	rootDirName := filepath.Base(rootDir)
-	builder.WriteString(rootDirName + "\n")
+	// Check if we have any file contents
+	if len(resp.Contents) == 0 && !req.DryRun {
+		log.Printf("Warning: No file contents were collected, %d files were skipped", len(resp.Skipped))
+	}
 
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Continue despite errors
+	// Save output to a file when persistence is enabled
+	if outputs != nil {
+		if err := saveOutputToFile(repoID, resp); err != nil {
+			log.Printf("Warning: Failed to save output to file: %v", err)
 		}
+	}
 
-		// Skip .git directory and all git-related files
-		if info.IsDir() && (info.Name() == ".git" || strings.HasPrefix(info.Name(), ".git")) {
-			return filepath.SkipDir
-		}
+	cache.put(cacheKey, resp)
+	return resp, repoID, nil
+}
 
-		// Skip git-related files
-		if strings.HasPrefix(info.Name(), ".git") {
-			return nil
+// checkoutRepo clones the repository of a validated request into a new temporary directory
+// and enforces the repository size limit. The caller must remove the returned directory
+// with cleanupRepo, even if an error is returned.
+func checkoutRepo(ctx context.Context, req *RepoRequest) (string, string, error) {
+	// Local directories are analyzed in place, cleanupRepo leaves them alone
+	if req.LocalPath != "" {
+		log.Printf("Analyzing local directory: %s", req.LocalPath)
+		repoID, err := newRepoID(req)
+		if err != nil {
+			return "", "", &requestError{http.StatusInternalServerError, "Failed to create repository ID: " + err.Error()}
 		}
+		return repoID, req.LocalPath, nil
+	}
 
-		// Skip the root directory itself
-		if path == rootDir {
-			return nil
-		}
+	// Create a unique directory for this repository
+	repoID, repoDir, err := createRepoDir(req)
+	if err != nil {
+		return "", "", err
+	}
 
-		// Calculate depth and indentation
-		rel, err := filepath.Rel(rootDir, path)
+	// Reject repositories that are too large before cloning them where the host tells us their size
+	sizeLimit := repoSizeLimitKB(req)
+	sizeChecked := false
+	if sizeLimit > 0 {
+		var err error
+		sizeChecked, err = checkGitHubRepoSize(ctx, req, sizeLimit)
+		if errors.Is(err, errRepoTooLarge) {
+			log.Printf("Rejecting repository: %v", err)
+			return repoID, repoDir, &requestError{http.StatusRequestEntityTooLarge, "Rejected repository: " + err.Error()}
+		}
 		if err != nil {
-			return nil
+			log.Printf("Warning: Could not determine repository size before cloning: %v", err)
 		}
+	}
 
-		depth := len(strings.Split(rel, string(os.PathSeparator)))
-		indent := strings.Repeat("│   ", depth-1)
-
-		// Add the appropriate prefix
-		if info.IsDir() {
-			builder.WriteString(indent + "├── " + info.Name() + "/\n")
-		} else {
-			builder.WriteString(indent + "├── " + info.Name() + "\n")
+	// Clone the repository
+	log.Printf("Cloning repository: %s", req.RepoURL)
+	cloneStart := time.Now()
+	err = cloneRepo(ctx, req, repoDir)
+	metrics.recordClone(time.Since(cloneStart), err)
+	if err != nil {
+		log.Printf("Failed to clone repository: %v", err)
+		if ctx.Err() != nil {
+			return repoID, repoDir, contextError(ctx)
 		}
+		status := http.StatusInternalServerError
+		if errors.Is(err, errRefNotFound) || errors.Is(err, errInvalidSubmodule) {
+			status = http.StatusBadRequest
+		}
+		return repoID, repoDir, &requestError{status, "Failed to clone repository: " + err.Error()}
+	}
 
-		return nil
-	})
+	// Otherwise enforce the limit on the disk usage of the clone. The size reported by the
+	// host does not include submodules.
+	if sizeLimit > 0 && (!sizeChecked || req.Submodules) {
+		size, err := directorySizeKB(repoDir)
+		if err == nil && size > sizeLimit {
+			err = fmt.Errorf("%w: %d KB exceeds the limit of %d KB", errRepoTooLarge, size, sizeLimit)
+			log.Printf("Rejecting repository: %v", err)
+			return repoID, repoDir, &requestError{http.StatusRequestEntityTooLarge, "Rejected repository: " + err.Error()}
+		}
+	}
 
-	return builder.String(), err
+	return repoID, repoDir, nil
 }
 
-// shouldIgnoreFile checks if a file should be ignored based on .gitignore rules
-func shouldIgnoreFile(path string) bool {
-	// Always skip .git directory and all subdirectories/files
-	if strings.Contains(path, "/.git/") || strings.HasSuffix(path, "/.git") || strings.HasPrefix(filepath.Base(path), ".git") {
-		return true
+// writeResponse writes an analysis in the requested format
+func writeResponse(w http.ResponseWriter, req *RepoRequest, resp *RepoResponse, format string) {
+	if resp.RepoID != "" {
+		w.Header().Set(repoIDHeader, resp.RepoID)
 	}
 
-	// Basic check for binary files (could be improved)
-	ext := strings.ToLower(filepath.Ext(path))
-	binaryExtensions := []string{".exe", ".dll", ".so", ".dylib", ".obj", ".o", ".a", ".lib",
-		".bin", ".dat", ".png", ".jpg", ".jpeg", ".gif", ".bmp", ".tiff", ".ico",
-		".mp3", ".mp4", ".mov", ".avi", ".wav", ".flac", ".zip", ".tar", ".gz", ".7z", ".rar"}
+	switch format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+	case "text", "txt":
+		w.Header().Set("Content-Type", "text/plain")
+		repoName := req.repoName()
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-analysis.txt", repoName))
+
+		// First add the directory tree
+		w.Write([]byte("# Directory Tree\n\n" + resp.Tree + "\n\n"))
+		// Then add the markdown content without the initial heading
+		markdownWithoutHeader := strings.Replace(resp.Markdown, "# Repository Analysis\n\n", "# File Contents\n\n", 1)
+		w.Write([]byte(markdownWithoutHeader))
+
+	case "xml":
+		w.Header().Set("Content-Type", "application/xml")
+		repoName := req.repoName()
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-analysis.xml", repoName))
+		w.Write([]byte(generateXMLDocument(resp.Tree, resp.Contents)))
+
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		repoName := req.repoName()
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-analysis.html", repoName))
+		w.Write([]byte(generateHTMLDocument("Repository Analysis: "+repoName, resp.Tree, resp.Contents, resp.Stats, req.LanguageOverrides, req.highlight)))
+
+	case "zip":
+		writeZipResponse(w, req, resp)
+
+	case "jsonl":
+		writeJSONLResponse(w, req, resp)
+
+	default: // markdown or any other value defaults to markdown
+		w.Header().Set("Content-Type", "text/markdown")
+		repoName := req.repoName()
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-analysis.md", repoName))
+		w.Write([]byte(resp.Markdown))
+	}
+}
+
+// extractRepoName extracts a repository name from its URL
+func extractRepoName(repoURL string) string {
+	// Browser URLs such as .../repo/tree/main/src name the repository before the ref
+	repoURL = normalizeRepoURL(repoURL).cloneURL
+
+	// Remove trailing .git if present
+	repoURL = strings.TrimSuffix(repoURL, ".git")
+
+	// Extract the last part of the URL path
+	parts := strings.Split(repoURL, "/")
+	if len(parts) > 0 {
+		return parts[len(parts)-1]
+	}
+
+	return "repo"
+}
+
+// repoLocation is a repository URL split into the URL to clone and the ref and path that
+// a browser URL points to
+type repoLocation struct {
+	cloneURL string
+	ref      string // Branch, tag or commit of a /tree/ or /blob/ URL
+	path     string // Directory or file inside the repository
+}
+
+// normalizeRepoURL turns a URL copied from the browser into the URL to clone. GitHub URLs
+// such as https://github.com/org/repo/tree/main/src/pkg or .../blob/main/README.md and
+// GitLab URLs with /-/tree/ or /-/blob/ also yield the ref and path they point to. Since
+// refs may contain slashes, the first segment after tree or blob is taken as the ref.
+// Other URLs are returned unchanged.
+func normalizeRepoURL(repoURL string) repoLocation {
+	location := repoLocation{cloneURL: repoURL}
+
+	parsedURL, err := url.Parse(repoURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.User != nil {
+		return location
+	}
+	host := strings.ToLower(parsedURL.Host)
+	if host == "www.github.com" {
+		host = "github.com"
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(parsedURL.Path, "/") {
+		if segment == "." || segment == ".." {
+			return location // Left for validateRepoURL to reject
+		}
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	// Split the path into the repository and the part after tree or blob
+	repoSegments := segments
+	var rest []string
+	isBlob := false
+	for i, segment := range segments {
+		isMarker := segment == "tree" || segment == "blob"
+		if i >= 1 && segment == "-" && i+1 < len(segments) && (segments[i+1] == "tree" || segments[i+1] == "blob") {
+			repoSegments, rest, isBlob = segments[:i], segments[i+2:], segments[i+1] == "blob"
+			break
+		}
+		if host == "github.com" && i == 2 && isMarker {
+			repoSegments, rest, isBlob = segments[:2], segments[3:], segment == "blob"
+			break
+		}
+	}
+
+	switch {
+	case rest != nil:
+		if len(rest) == 0 || (isBlob && len(rest) < 2) {
+			return location // A tree or blob URL without a ref or file
+		}
+		location.ref = rest[0]
+		location.path = strings.Join(rest[1:], "/")
+	case host == "github.com" && len(segments) == 2:
+		// A plain repository URL, possibly with a trailing slash, query or fragment
+	default:
+		return location
+	}
+
+	repoPath := strings.Join(repoSegments, "/")
+	if !strings.HasSuffix(repoPath, ".git") {
+		repoPath += ".git"
+	}
+	location.cloneURL = parsedURL.Scheme + "://" + host + "/" + repoPath
+	return location
+}
+
+// applyRepoURL normalizes the repository URL of a request. The ref and path of a browser
+// URL are only used when the request does not set its own ref or include dirs.
+func applyRepoURL(req *RepoRequest) {
+	location := normalizeRepoURL(req.RepoURL)
+	req.RepoURL = location.cloneURL
+
+	if location.ref != "" && req.Branch == "" && req.Tag == "" && req.Commit == "" {
+		if len(location.ref) == 40 && commitPattern.MatchString(location.ref) {
+			req.Commit = location.ref
+		} else {
+			// git clone --branch also accepts tags, so the ref can be either
+			req.Branch = location.ref
+		}
+	}
+
+	if location.path == "" {
+		return
+	}
+	for _, dir := range req.Dirs {
+		if !dir.Exclude {
+			return
+		}
+	}
+	req.Dirs = append(req.Dirs, DirRequest{Path: location.path})
+}
+
+// allowedHosts returns the git hosts repositories may be cloned from
+func allowedHosts() []string {
+	value := os.Getenv("ALLOWED_GIT_HOSTS")
+	if value == "" {
+		return defaultAllowedHosts
+	}
+
+	var hosts []string
+	for _, host := range strings.Split(value, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// validateRepoURL checks that a repository URL uses http, https or ssh and points at
+// an allowed git host, so it cannot smuggle options or local paths into git
+func validateRepoURL(repoURL string) error {
+	if strings.HasPrefix(repoURL, "-") {
+		return fmt.Errorf("URL must not start with '-'")
+	}
+	for _, r := range repoURL {
+		if r <= ' ' || r == 0x7f {
+			return fmt.Errorf("URL must not contain whitespace or control characters")
+		}
+	}
+
+	parsedURL, err := parseRepoURL(repoURL)
+	if err != nil {
+		return err
+	}
+
+	switch parsedURL.Scheme {
+	case "http", "https":
+		if parsedURL.User != nil {
+			return fmt.Errorf("URL must not contain credentials")
+		}
+	case "ssh":
+		if _, hasPassword := parsedURL.User.Password(); hasPassword {
+			return fmt.Errorf("URL must not contain credentials")
+		}
+	default:
+		return fmt.Errorf("unsupported scheme %q, expected http, https or ssh", parsedURL.Scheme)
+	}
+
+	if parsedURL.RawQuery != "" || parsedURL.Fragment != "" {
+		return fmt.Errorf("URL must not contain a query or fragment")
+	}
+
+	repoPath := strings.Trim(parsedURL.Path, "/")
+	if repoPath == "" {
+		return fmt.Errorf("URL is missing the repository path")
+	}
+	for _, segment := range strings.Split(repoPath, "/") {
+		if segment == "." || segment == ".." || strings.HasPrefix(segment, "-") {
+			return fmt.Errorf("URL contains an invalid path segment %q", segment)
+		}
+	}
+
+	host := strings.ToLower(parsedURL.Hostname())
+	for _, allowed := range allowedHosts() {
+		if host == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %q is not allowed", host)
+}
+
+// validateRefs checks that the branch, tag and commit in a request are well-formed
+// and cannot be interpreted by git as command line options
+func validateRefs(req *RepoRequest) error {
+	if req.Branch != "" && req.Tag != "" {
+		return fmt.Errorf("only one of branch or tag may be specified")
+	}
+
+	for _, ref := range []string{req.Branch, req.Tag, req.DiffBase} {
+		if ref == "" {
+			continue
+		}
+		if strings.HasPrefix(ref, "-") || strings.Contains(ref, "..") || !refPattern.MatchString(ref) {
+			return fmt.Errorf("invalid ref %q", ref)
+		}
+	}
+
+	if req.Commit != "" && !commitPattern.MatchString(req.Commit) {
+		return fmt.Errorf("invalid commit SHA %q", req.Commit)
+	}
+
+	if req.Depth != nil && *req.Depth < 0 {
+		return fmt.Errorf("depth must not be negative")
+	}
+
+	if req.MaxFileSize < 0 {
+		return fmt.Errorf("max_file_size must not be negative")
+	}
+
+	if req.MaxFileLines < 0 || req.MaxFileBytes < 0 || req.MaxTotalBytes < 0 {
+		return fmt.Errorf("max_file_lines, max_file_bytes and max_total_bytes must not be negative")
+	}
+
+	for _, dir := range req.Dirs {
+		if dir.MaxDepth < 0 {
+			return fmt.Errorf("max_depth of %q must not be negative", dir.Path)
+		}
+		// Patterns are matched against the whole repository, so there is no directory to count from
+		if dir.MaxDepth > 0 && !dir.Exclude && isGlobPattern(dir.Path) {
+			return fmt.Errorf("max_depth cannot be used with the glob pattern %q", dir.Path)
+		}
+	}
+
+	return nil
+}
+
+// cloneDepth returns the clone depth for a request, where 0 means full history
+func cloneDepth(req *RepoRequest) int {
+	// An arbitrary commit is generally not reachable from a shallow clone
+	if req.Commit != "" {
+		return 0
+	}
+	if req.Depth == nil {
+		return defaultCloneDepth
+	}
+	return *req.Depth
+}
+
+// isRefNotFoundOutput reports whether git output indicates a missing branch, tag or commit
+func isRefNotFoundOutput(output string) bool {
+	markers := []string{
+		"not found in upstream",
+		"did not match any",
+		"reference is not a tree",
+		"unknown revision",
+		"couldn't find remote ref",
+	}
+	for _, marker := range markers {
+		if strings.Contains(output, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// cloneRepo clones a GitHub repository to the specified directory, checking out
+// the requested branch, tag or commit if one is given
+func cloneRepo(ctx context.Context, req *RepoRequest, repoDir string) error {
+	log.Printf("Cloning repository %s to %s", req.RepoURL, repoDir)
+
+	// If it's a private repository, set up authentication
+	auth, err := prepareAuth(req)
+	defer auth.cleanup() // Remove temporary key files even if the clone fails
+	if err != nil {
+		return err
+	}
+
+	// Construct git clone command
+	// Use --config core.autocrlf=input to normalize line endings
+	args := []string{"clone", "--config", "core.autocrlf=input"}
+
+	ref := req.Branch
+	if ref == "" {
+		ref = req.Tag
+	}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	if depth := cloneDepth(req); depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(depth), "--single-branch")
+	}
+	// Terminate options so git never interprets the URL or directory as a flag
+	args = append(args, "--", req.RepoURL, repoDir)
+
+	// Execute the command
+	output, err := runGit(ctx, auth, args...)
+	if err != nil {
+		if ref != "" && isRefNotFoundOutput(output) {
+			return fmt.Errorf("%w: branch or tag %q does not exist", errRefNotFound, ref)
+		}
+		return fmt.Errorf("git clone failed: %v - %s", err, output)
+	}
+
+	// Check out a specific commit on top of the cloned branch
+	if req.Commit != "" {
+		log.Printf("Checking out commit %s", req.Commit)
+		output, err := runGit(ctx, auth, "-C", repoDir, "checkout", "--quiet", req.Commit)
+		if err != nil {
+			if isRefNotFoundOutput(output) {
+				return fmt.Errorf("%w: commit %q does not exist", errRefNotFound, req.Commit)
+			}
+			return fmt.Errorf("git checkout failed: %v - %s", err, output)
+		}
+	}
+
+	// Fetch submodules at the revisions recorded by the checked out commit
+	if req.Submodules {
+		if err := updateSubmodules(ctx, auth, repoDir, 1); err != nil {
+			return err
+		}
+	}
+
+	// Find the files to analyze in diff mode
+	if req.DiffBase != "" {
+		if req.changes, err = diffChanges(ctx, auth, repoDir, req); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// analyzeRepo analyzes a repository and returns its directory tree and file contents
+func analyzeRepo(ctx context.Context, repoDir string, req *RepoRequest) (*RepoResponse, error) {
+	// Generate directory tree
+	tree, err := generateDirectoryTree(ctx, repoDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate directory tree: %v", err)
+	}
+
+	// A dry run only reports which files would be included
+	if req.DryRun {
+		return listRepoFiles(ctx, repoDir, tree, req)
+	}
+
+	// Extract file contents
+	extracted, err := extractFileContents(ctx, repoDir, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract file contents: %v", err)
+	}
+	contents := extracted.contents
+
+	// Generate markdown document with tree included
+	markdown := generateMarkdownDocument(tree, extracted, req)
+
+	// Note the files left out because of the output budget
+	if extracted.omitted > 0 {
+		var note strings.Builder
+		note.WriteString("---\n\n")
+		writeBudgetNote(&note, req.MaxTotalBytes, extracted.omitted)
+		markdown += note.String()
+	}
+
+	resp := &RepoResponse{
+		Tree:         tree,
+		Contents:     contents,
+		Markdown:     markdown,
+		Skipped:      extracted.skipped,
+		Stats:        extracted.stats,
+		Changes:      req.changes,
+		Capped:       extracted.omitted > 0,
+		OmittedFiles: extracted.omitted,
+	}
+	for path := range extracted.outlined {
+		resp.Outlined = append(resp.Outlined, path)
+	}
+	sort.Strings(resp.Outlined)
+
+	// Estimate token usage and append a summary of the largest files
+	if req.CountTokens {
+		resp.FileTokens = make(map[string]int, len(contents))
+		for path, content := range contents {
+			resp.FileTokens[path] = estimateTokens(content)
+		}
+		resp.TokenCount = estimateTokens(markdown)
+		resp.Markdown += generateTokenSummary(resp.TokenCount, resp.FileTokens)
+	}
+
+	return resp, nil
+}
+
+// listRepoFiles applies the include, exclude and filter rules of a request without reading
+// any file, and returns the tree with the paths that would be included and those skipped.
+// Binary files that only reveal themselves by their content are listed as included.
+func listRepoFiles(ctx context.Context, repoDir, tree string, req *RepoRequest) (*RepoResponse, error) {
+	candidates, skipped, err := collectCandidates(ctx, repoDir, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect files: %v", err)
+	}
+
+	files := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		files[i] = candidate.relPath
+	}
+	sort.Strings(files)
+	sortSkipped(skipped)
+
+	return &RepoResponse{
+		Tree:     tree,
+		Contents: map[string]string{},
+		Markdown: generateDryRunDocument(tree, files, skipped),
+		Skipped:  skipped,
+		DryRun:   true,
+		Files:    files,
+		Changes:  req.changes,
+	}, nil
+}
+
+// generateDryRunDocument creates a markdown document with the directory tree and the paths
+// a dry run would include and skip
+func generateDryRunDocument(tree string, files []string, skipped []SkippedFile) string {
+	var builder strings.Builder
+	builder.WriteString("# Repository Analysis (Dry Run)\n\n")
+	builder.WriteString("## Directory Tree\n\n```\n" + tree + "\n```\n\n")
+
+	builder.WriteString(fmt.Sprintf("## Included Files (%d)\n\n", len(files)))
+	for _, file := range files {
+		builder.WriteString("- " + file + "\n")
+	}
+
+	if len(skipped) > 0 {
+		builder.WriteString(fmt.Sprintf("\n## Skipped Files (%d)\n\n", len(skipped)))
+		for _, file := range skipped {
+			builder.WriteString(fmt.Sprintf("- %s (%s)\n", file.Path, file.Reason))
+		}
+	}
+
+	return builder.String()
+}
+
+// generateMarkdownDocument creates a markdown document with statistics, directory tree and all file contents
+func generateMarkdownDocument(tree string, extracted *extractionResult, req *RepoRequest) string {
+	var builder strings.Builder
+	contents := extracted.contents
+
+	writeMarkdownHeader(&builder, "Repository Analysis", tree, extracted.stats, req)
+
+	// Get sorted keys for consistent output
+	keys := make([]string, 0, len(contents))
+	for k := range contents {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// Add each file with markdown formatting
+	for _, path := range keys {
+		writeMarkdownFile(&builder, path, contents[path], extracted.outlined[path], req.LanguageOverrides)
+	}
+
+	return builder.String()
+}
+
+// writeMarkdownHeader writes the title, the statistics if known, the directory tree, the
+// changed files of a diff and the heading of the file contents section
+func writeMarkdownHeader(w io.Writer, title, tree string, stats *RepoStats, req *RepoRequest) {
+	// Add title
+	io.WriteString(w, "# "+title+"\n\n")
+
+	// Add statistics
+	if stats != nil {
+		io.WriteString(w, generateStatsTable(stats))
+	}
+
+	// Add directory tree
+	io.WriteString(w, "## Directory Tree\n\n```\n")
+	io.WriteString(w, tree)
+	io.WriteString(w, "\n```\n\n")
+
+	// Add the changes of a diff
+	if req.DiffBase != "" {
+		writeChangesSection(w, req.DiffBase, req.changes)
+	}
+
+	// Add file contents section
+	io.WriteString(w, "## File Contents\n\n")
+}
+
+// writeMarkdownFile writes a single file as a fenced code block. Outlined files are labeled
+// as such, since their content is not the complete file.
+func writeMarkdownFile(w io.Writer, path, content string, outlined bool, overrides map[string]string) {
+	// Add file header with horizontal rule
+	io.WriteString(w, "---\n\n")
+	if outlined {
+		fmt.Fprintf(w, "### %s (outline)\n\n", path)
+	} else {
+		fmt.Fprintf(w, "### %s\n\n", path)
+	}
+
+	// Determine language for syntax highlighting
+	language := determineLanguage(path, overrides)
+
+	// Add content with code fence
+	if language != "" {
+		fmt.Fprintf(w, "```%s\n%s\n```\n\n", language, content)
+	} else {
+		fmt.Fprintf(w, "```\n%s\n```\n\n", content)
+	}
+}
+
+// generateStatsTable creates a markdown table with per-language file, line and byte counts
+func generateStatsTable(stats *RepoStats) string {
+	var builder strings.Builder
+
+	builder.WriteString("## Statistics\n\n")
+	builder.WriteString("| Language | Files | Lines | Bytes |\n|----------|-------|-------|-------|\n")
+
+	for _, language := range stats.sortedLanguages() {
+		lang := stats.Languages[language]
+		builder.WriteString(fmt.Sprintf("| %s | %d | %d | %d |\n", language, lang.Files, lang.Lines, lang.Bytes))
+	}
+	builder.WriteString(fmt.Sprintf("| **Total** | %d | %d | %d |\n\n", stats.TotalFiles, stats.TotalLines, stats.TotalBytes))
+
+	return builder.String()
+}
+
+// generateXMLDocument creates an XML document with the directory tree and one <file> element
+// per file, following the XML tag structure recommended for Claude prompts
+func generateXMLDocument(tree string, contents map[string]string) string {
+	var builder strings.Builder
+
+	builder.WriteString(xml.Header)
+	builder.WriteString("<repository>\n")
+
+	// Add directory tree
+	builder.WriteString("<tree>")
+	builder.WriteString(xmlCDATA(tree))
+	builder.WriteString("</tree>\n")
+
+	// Get sorted keys for consistent output
+	keys := make([]string, 0, len(contents))
+	for k := range contents {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// Add each file as its own element
+	for _, path := range keys {
+		builder.WriteString(`<file path="`)
+		xml.EscapeText(&builder, []byte(path))
+		builder.WriteString(`">`)
+		builder.WriteString(xmlCDATA(contents[path]))
+		builder.WriteString("</file>\n")
+	}
+
+	builder.WriteString("</repository>\n")
+	return builder.String()
+}
+
+// xmlCDATA wraps text in a CDATA section, splitting any "]]>" sequences and replacing
+// characters that are not allowed in XML documents
+func xmlCDATA(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || (r >= 0x20 && r <= 0xD7FF) || (r >= 0xE000 && r <= 0xFFFD) || r >= 0x10000 {
+			return r
+		}
+		return '\uFFFD'
+	}, text)
+
+	return "<![CDATA[" + strings.ReplaceAll(text, "]]>", "]]]]><![CDATA[>") + "]]>"
+}
+
+// basenameLanguages maps well-known file names, compared in lower case, to their languages
+var basenameLanguages = map[string]string{
+	"dockerfile":     "dockerfile",
+	"containerfile":  "dockerfile",
+	"makefile":       "makefile",
+	"gnumakefile":    "makefile",
+	"cmakelists.txt": "cmake",
+	"jenkinsfile":    "groovy",
+	"vagrantfile":    "ruby",
+	"gemfile":        "ruby",
+	"rakefile":       "ruby",
+	"podfile":        "ruby",
+	"go.mod":         "go",
+	"go.sum":         "text",
+	".bashrc":        "bash",
+	".bash_profile":  "bash",
+	".profile":       "bash",
+	".zshrc":         "bash",
+	".env":           "dotenv",
+	".editorconfig":  "ini",
+	".npmrc":         "ini",
+	".babelrc":       "json",
+	".eslintrc":      "json",
+	".prettierrc":    "json",
+	".dockerignore":  "text",
+}
+
+// extensionLanguages maps common file extensions to their languages
+var extensionLanguages = map[string]string{
+	".go":         "go",
+	".js":         "javascript",
+	".mjs":        "javascript",
+	".cjs":        "javascript",
+	".jsx":        "jsx",
+	".ts":         "typescript",
+	".mts":        "typescript",
+	".tsx":        "tsx",
+	".vue":        "vue",
+	".svelte":     "svelte",
+	".py":         "python",
+	".pyi":        "python",
+	".ipynb":      "json",
+	".java":       "java",
+	".kt":         "kotlin",
+	".kts":        "kotlin",
+	".scala":      "scala",
+	".groovy":     "groovy",
+	".gradle":     "groovy",
+	".clj":        "clojure",
+	".sh":         "bash",
+	".bash":       "bash",
+	".zsh":        "bash",
+	".fish":       "fish",
+	".ps1":        "powershell",
+	".bat":        "batch",
+	".cmd":        "batch",
+	".md":         "markdown",
+	".mdx":        "mdx",
+	".rst":        "rst",
+	".tex":        "latex",
+	".html":       "html",
+	".htm":        "html",
+	".css":        "css",
+	".scss":       "scss",
+	".sass":       "sass",
+	".less":       "less",
+	".json":       "json",
+	".jsonc":      "json",
+	".yaml":       "yaml",
+	".yml":        "yaml",
+	".toml":       "toml",
+	".ini":        "ini",
+	".cfg":        "ini",
+	".conf":       "ini",
+	".properties": "properties",
+	".xml":        "xml",
+	".svg":        "xml",
+	".sql":        "sql",
+	".graphql":    "graphql",
+	".gql":        "graphql",
+	".proto":      "protobuf",
+	".tf":         "hcl",
+	".hcl":        "hcl",
+	".c":          "c",
+	".h":          "c",
+	".cpp":        "cpp",
+	".cc":         "cpp",
+	".cxx":        "cpp",
+	".hpp":        "cpp",
+	".hh":         "cpp",
+	".cs":         "csharp",
+	".fs":         "fsharp",
+	".m":          "objectivec",
+	".mm":         "objectivec",
+	".rb":         "ruby",
+	".php":        "php",
+	".pl":         "perl",
+	".lua":        "lua",
+	".r":          "r",
+	".jl":         "julia",
+	".dart":       "dart",
+	".rs":         "rust",
+	".swift":      "swift",
+	".zig":        "zig",
+	".nim":        "nim",
+	".ex":         "elixir",
+	".exs":        "elixir",
+	".erl":        "erlang",
+	".hs":         "haskell",
+	".ml":         "ocaml",
+	".elm":        "elm",
+	".sol":        "solidity",
+	".cmake":      "cmake",
+	".mk":         "makefile",
+	".dockerfile": "dockerfile",
+	".diff":       "diff",
+	".patch":      "diff",
+}
+
+// determineLanguage determines the language for syntax highlighting of a file. Overrides from
+// the request are checked first, then well-known file names and finally the file extension.
+func determineLanguage(path string, overrides map[string]string) string {
+	if language, ok := languageOverride(path, overrides); ok {
+		return language
+	}
+
+	base := strings.ToLower(filepath.Base(path))
+	if language, ok := basenameLanguages[base]; ok {
+		return language
+	}
+	// Variants such as Dockerfile.dev
+	if strings.HasPrefix(base, "dockerfile.") || strings.HasPrefix(base, "containerfile.") {
+		return "dockerfile"
+	}
+
+	extension := strings.ToLower(filepath.Ext(path))
+	if language, ok := extensionLanguages[extension]; ok {
+		return language
+	}
+
+	return ""
+}
+
+// isExtensionOverride reports whether a language override key is a plain extension such as ".tpl"
+func isExtensionOverride(key string) bool {
+	return strings.HasPrefix(key, ".") && !strings.Contains(key, "/") && !isGlobPattern(key)
+}
+
+// languageOverride returns the language a request forces for a file. Keys are either
+// extensions or paths and glob patterns; keys without a "/" match the file name in any
+// directory. Path and glob keys take precedence over extensions, and longer keys over
+// shorter ones, so the most specific override wins.
+func languageOverride(path string, overrides map[string]string) (string, bool) {
+	if len(overrides) == 0 {
+		return "", false
+	}
+
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if a, b := isExtensionOverride(keys[i]), isExtensionOverride(keys[j]); a != b {
+			return b
+		}
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	extension := strings.ToLower(filepath.Ext(path))
+	for _, key := range keys {
+		var matched bool
+		switch {
+		case isExtensionOverride(key):
+			matched = strings.ToLower(key) == extension
+		case strings.Contains(key, "/"):
+			matched = matchGlob(normalizePattern(key), path)
+		default:
+			matched = matchGlob(key, filepath.Base(path))
+		}
+		if matched {
+			return overrides[key], true
+		}
+	}
+	return "", false
+}
+
+// validateLanguageOverrides checks that override keys are valid patterns and that languages
+// are plain names, as they end up in the info string of markdown code fences
+func validateLanguageOverrides(overrides map[string]string) error {
+	for key, language := range overrides {
+		if key == "" {
+			return fmt.Errorf("empty pattern")
+		}
+		if _, err := filepath.Match(key, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", key)
+		}
+		if err := validatePatternSize(key); err != nil {
+			return err
+		}
+		if !languagePattern.MatchString(language) {
+			return fmt.Errorf("invalid language %q for %q", language, key)
+		}
+	}
+	return nil
+}
+
+// generateDirectoryTree generates a text representation of the repository directory structure
+func generateDirectoryTree(ctx context.Context, repoDir string) (string, error) {
+	log.Printf("Generating directory tree for %s", repoDir)
+
+	// Use the external 'tree' command or a custom implementation
+	cmd := exec.CommandContext(ctx, "tree", "-a", "-I", ".git", "--gitignore", repoDir)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	// If 'tree' command is not available, use a custom implementation
+	if err != nil {
+		log.Println("External 'tree' command failed, using custom implementation")
+		return generateCustomDirectoryTree(ctx, repoDir)
+	}
 
-	for _, binaryExt := range binaryExtensions {
-		if ext == binaryExt {
-			return true
+	return string(output), nil
+}
+
+// generateCustomDirectoryTree creates a directory tree structure without external dependencies,
+// in the same layout as the 'tree' command and honoring .gitignore files like its --gitignore flag
+func generateCustomDirectoryTree(ctx context.Context, rootDir string) (string, error) {
+	var builder strings.Builder
+	rootDirName := filepath.Base(rootDir)
+	builder.WriteString(rootDirName + "\n")
+
+	ignore := loadGitignore(rootDir)
+	dirCount, fileCount := 0, 0
+
+	// writeDir lists a directory below the entries of its parent. prefix holds the
+	// continuation of every ancestor: "│   " while it has more siblings, "    " otherwise.
+	var writeDir func(dir, relDir, prefix string) error
+	writeDir = func(dir, relDir, prefix string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
 		}
+
+		// Entries are listed in name order, so only the visible ones decide which is last
+		var visible []os.DirEntry
+		for _, entry := range entries {
+			// Skip the .git directory like 'tree -I .git', files such as .gitignore are listed
+			if entry.Name() == ".git" {
+				continue
+			}
+			if ignore.isIgnored(path.Join(relDir, entry.Name()), entry.IsDir()) {
+				continue
+			}
+			visible = append(visible, entry)
+		}
+
+		for i, entry := range visible {
+			branch, continuation := "├── ", "│   "
+			if i == len(visible)-1 {
+				branch, continuation = "└── ", "    "
+			}
+			builder.WriteString(prefix + branch + entry.Name() + "\n")
+
+			if !entry.IsDir() {
+				fileCount++
+				continue
+			}
+			dirCount++
+			if err := writeDir(filepath.Join(dir, entry.Name()), path.Join(relDir, entry.Name()), prefix+continuation); err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				log.Printf("Warning: Failed to list directory %s: %v", entry.Name(), err)
+			}
+		}
+		return nil
+	}
+
+	err := writeDir(rootDir, "", "")
+	builder.WriteString(fmt.Sprintf("\n%s, %s\n", pluralize(dirCount, "directory", "directories"), pluralize(fileCount, "file", "files")))
+
+	return builder.String(), err
+}
+
+// pluralize formats a count with the singular or plural form of a noun
+func pluralize(count int, singular, plural string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, singular)
 	}
+	return fmt.Sprintf("%d %s", count, plural)
+}
 
+// defaultBinaryExtensions lists extensions that are skipped as binary unless force-included
+var defaultBinaryExtensions = []string{".exe", ".dll", ".so", ".dylib", ".obj", ".o", ".a", ".lib",
+	".bin", ".dat", ".png", ".jpg", ".jpeg", ".gif", ".bmp", ".tiff", ".ico",
+	".mp3", ".mp4", ".mov", ".avi", ".wav", ".flac", ".zip", ".tar", ".gz", ".7z", ".rar"}
+
+// containsExtension reports whether ext is in the list, ignoring case and an optional leading dot
+func containsExtension(extensions []string, ext string) bool {
+	for _, candidate := range extensions {
+		candidate = strings.ToLower(candidate)
+		if !strings.HasPrefix(candidate, ".") {
+			candidate = "." + candidate
+		}
+		if candidate == ext {
+			return true
+		}
+	}
 	return false
 }
 
-// extractFileContents extracts the contents of the files in the specified directories
-func extractFileContents(repoDir string, dirRequests []DirRequest) (map[string]string, error) {
-	contents := make(map[string]string)
+// ignoreReason checks if a file should be ignored based on its name and extension,
+// returning an empty reason if it should be kept.
+// Precedence, from highest to lowest:
+//  1. Git metadata is always ignored
+//  2. extraExclude extensions are always ignored
+//  3. forceInclude extensions are kept, even if they are in the default binary list
+//  4. defaultBinaryExtensions are ignored
+func ignoreReason(path string, extraExclude, forceInclude []string) SkipReason {
+	// Always skip .git directory and all subdirectories/files
+	if strings.Contains(path, "/.git/") || strings.HasSuffix(path, "/.git") || strings.HasPrefix(filepath.Base(path), ".git") {
+		return SkipExcluded
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == "" {
+		return ""
+	}
+
+	if containsExtension(extraExclude, ext) {
+		return SkipExcluded
+	}
+	if containsExtension(forceInclude, ext) {
+		return ""
+	}
+
+	// Fast pre-filter for well-known binary formats, file contents are checked when read
+	if containsExtension(defaultBinaryExtensions, ext) {
+		return SkipBinary
+	}
+	return ""
+}
+
+// extractFileContents extracts the contents of the files in the specified directories.
+// Every file that is left out is reported in the returned skipped manifest.
+func extractFileContents(ctx context.Context, repoDir string, req *RepoRequest) (*extractionResult, error) {
+	candidates, skipped, err := collectCandidates(ctx, repoDir, req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := readCandidates(ctx, candidates, readWorkers(), req)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	result.skipped = append(skipped, result.skipped...)
+
+	// Report skipped files in a stable order
+	sortSkipped(result.skipped)
+	metrics.recordSkipped(result.skipped)
+
+	return result, nil
+}
+
+// sortSkipped orders a skipped manifest by path
+func sortSkipped(skipped []SkippedFile) {
+	sort.Slice(skipped, func(i, j int) bool {
+		return skipped[i].Path < skipped[j].Path
+	})
+}
+
+// collectCandidates selects the files in the requested directories without reading them,
+// returning the files to read and the files that were skipped based on their path or size.
+// Include and exclude paths may be glob patterns; exclusions always win over inclusions.
+func collectCandidates(ctx context.Context, repoDir string, req *RepoRequest) ([]fileCandidate, []SkippedFile, error) {
+	var candidates []fileCandidate
+	skipped := []SkippedFile{} // Serialized as an empty array rather than null
+	seen := make(map[string]bool)
+
+	skip := func(relPath string, reason SkipReason) {
+		skipped = append(skipped, SkippedFile{Path: relPath, Reason: reason})
+	}
+
+	// A zero limit falls back to the service default
+	sizeLimit := req.MaxFileSize
+	if sizeLimit == 0 {
+		sizeLimit = maxFileSize
+	}
 
 	// Collect exclude paths (directories and files)
 	var excludePaths []string
 	var includePaths []DirRequest
 
 	// Separate include and exclude paths
-	for _, dirReq := range dirRequests {
+	for _, dirReq := range req.Dirs {
 		if dirReq.Exclude {
 			excludePaths = append(excludePaths, dirReq.Path)
 		} else {
@@ -467,56 +1802,151 @@ func extractFileContents(repoDir string, dirRequests []DirRequest) (map[string]s
 	// If we only have exclusions but no inclusions, include everything except exclusions
 	if len(includePaths) == 0 {
 		includePaths = append(includePaths, DirRequest{
-			Path:      ".",
-			Recursive: true,
+			Path: ".",
 		})
 	}
 
+	// Load .gitignore rules unless the caller asked for ignored files as well
+	var ignore *gitignoreMatcher
+	if !req.IncludeIgnored {
+		ignore = loadGitignore(repoDir)
+	}
+
+	// Repository owners can exclude files with a .dumpignore file, or list the only files to
+	// include in a .dumpinclude file, both in gitignore syntax
+	dumpIgnore := loadRootIgnoreFile(repoDir, dumpIgnoreFile)
+	dumpInclude := loadRootIgnoreFile(repoDir, dumpIncludeFile)
+
+	changed := req.changedPaths()
+
+	// collectFile selects a file for reading unless it is excluded, ignored, binary or too large
+	collectFile := func(filePath string, info os.FileInfo) {
+		relPath, err := filepath.Rel(repoDir, filePath)
+		if err != nil {
+			return
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		// Skip .git files, including the .git file that links a submodule to its repository
+		if strings.HasPrefix(relPath, ".git/") || strings.Contains(relPath, "/.git/") || relPath == ".git" || strings.HasSuffix(relPath, "/.git") {
+			return
+		}
+
+		// In diff mode only files that changed are of interest
+		if changed != nil && !changed[relPath] {
+			return
+		}
+
+		// Overlapping include paths may select the same file more than once
+		if seen[relPath] {
+			return
+		}
+		seen[relPath] = true
+
+		// Check if path should be excluded - exact match, in excluded directory or matching a pattern
+		if isExcludedPath(relPath, excludePaths) {
+			skip(relPath, SkipExcluded)
+			return
+		}
+
+		// Skip files matched by .gitignore rules
+		if ignore.isIgnored(relPath, false) {
+			skip(relPath, SkipGitignored)
+			return
+		}
+
+		// Skip files excluded by the repository owner
+		if dumpIgnore.isIgnored(relPath, false) || (dumpInclude != nil && !dumpInclude.isIgnored(relPath, false)) {
+			skip(relPath, SkipDumpignored)
+			return
+		}
+
+		// Skip files that usually hold credentials, such as .env files and private keys
+		if isSensitiveFile(relPath, req) {
+			skip(relPath, SkipSensitive)
+			return
+		}
+
+		// Symbolic links may point outside the repository, so only regular files are read
+		if !info.Mode().IsRegular() {
+			skip(relPath, SkipNotRegular)
+			return
+		}
+
+		// Skip known binary extensions and large files without reading them
+		if reason := ignoreReason(filePath, req.ExtraExcludeExtensions, req.ForceIncludeExtensions); reason != "" {
+			skip(relPath, reason)
+			return
+		}
+		if info.Size() > sizeLimit {
+			skip(relPath, SkipTooLarge)
+			return
+		}
+
+		candidates = append(candidates, fileCandidate{fullPath: filePath, relPath: relPath})
+	}
+
 	// Process include directories/files
 	for _, dirReq := range includePaths {
 		fullPath := filepath.Join(repoDir, dirReq.Path)
 
-		// Check if path exists
-		fileInfo, err := os.Stat(fullPath)
-		if os.IsNotExist(err) {
+		// validateDirs rejects such paths already, this keeps other callers from leaving the repository
+		if fullPath != filepath.Clean(repoDir) && !isInsideDir(repoDir, fullPath) {
+			log.Printf("Warning: Ignoring include path %q outside of the repository", dirReq.Path)
 			continue
 		}
 
-		// Handle file vs directory differently
-		if !fileInfo.IsDir() {
-			// It's a single file
-			relPath, err := filepath.Rel(repoDir, fullPath)
-			if err != nil {
-				continue
-			}
+		// A path through a symbolic link, such as link/file with link pointing to /etc, would
+		// still leave it
+		if !resolvesInsideDir(repoDir, fullPath) {
+			log.Printf("Warning: Ignoring include path %q that resolves outside of the repository", dirReq.Path)
+			continue
+		}
 
-			// Check if file should be excluded by name
-			shouldExclude := false
-			for _, excludePath := range excludePaths {
-				if relPath == excludePath {
-					shouldExclude = true
-					break
+		// Check if path exists, without following a final symbolic link
+		fileInfo, err := os.Lstat(fullPath)
+		if os.IsNotExist(err) {
+			// A path that does not exist literally may still be a glob pattern
+			if isGlobPattern(dirReq.Path) {
+				if err := collectMatchingFiles(ctx, repoDir, normalizePattern(dirReq.Path), ignore, collectFile); err != nil {
+					return nil, nil, err
 				}
 			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Warning: Could not read %s: %v", dirReq.Path, err)
+			skipped = append(skipped, readErrorEntry(filepath.ToSlash(filepath.Clean(dirReq.Path)), err))
+			continue
+		}
 
-			if shouldExclude || shouldIgnoreFile(fullPath) || fileInfo.Size() > maxFileSize {
-				continue
-			}
-
-			// Read file content
-			data, err := ioutil.ReadFile(fullPath)
-			if err == nil {
-				contents[relPath] = string(data)
-			}
+		// Handle file vs directory differently
+		if !fileInfo.IsDir() {
+			// It's a single file
+			collectFile(fullPath, fileInfo)
 			continue
 		}
 
 		// It's a directory, walk it
-		recursive := dirReq.Recursive != false
+		recursive := dirReq.isRecursive()
 
-		filepath.Walk(fullPath, func(path string, info os.FileInfo, err error) error {
+		err = filepath.Walk(fullPath, func(path string, info os.FileInfo, err error) error {
+			// Abort the walk as soon as the analysis is cancelled or times out
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			if err != nil {
-				return nil // Continue to other files
+				// Record entries that cannot be read, such as directories without permission to
+				// list them, and continue with other files
+				if relPath, relErr := filepath.Rel(repoDir, path); relErr == nil {
+					relPath = filepath.ToSlash(relPath)
+					if info != nil && info.IsDir() {
+						relPath += "/"
+					}
+					log.Printf("Warning: Could not read %s: %v", relPath, err)
+					skipped = append(skipped, readErrorEntry(relPath, err))
+				}
+				return nil
 			}
 
 			// Skip if it's a directory
@@ -526,53 +1956,402 @@ func extractFileContents(repoDir string, dirRequests []DirRequest) (map[string]s
 					return filepath.SkipDir
 				}
 
-				// Skip subdirectories if non-recursive
+				// Only list the immediate files of the directory if non-recursive
 				if !recursive && path != fullPath {
 					return filepath.SkipDir
 				}
 
+				// Files below this directory would lie deeper than max_depth levels
+				if dirReq.MaxDepth > 0 && path != fullPath && walkDepth(fullPath, path) >= dirReq.MaxDepth {
+					return filepath.SkipDir
+				}
+
+				// Skip directories matched by .gitignore rules
+				if relPath, err := filepath.Rel(repoDir, path); err == nil && path != fullPath && ignore.isIgnored(filepath.ToSlash(relPath), true) {
+					if relPath = filepath.ToSlash(relPath); !seen[relPath+"/"] {
+						seen[relPath+"/"] = true
+						skip(relPath+"/", SkipGitignored)
+					}
+					return filepath.SkipDir
+				}
+
+				// Skip directories matched by .dumpignore rules
+				if relPath, err := filepath.Rel(repoDir, path); err == nil && path != fullPath && dumpIgnore.isIgnored(filepath.ToSlash(relPath), true) {
+					if relPath = filepath.ToSlash(relPath); !seen[relPath+"/"] {
+						seen[relPath+"/"] = true
+						skip(relPath+"/", SkipDumpignored)
+					}
+					return filepath.SkipDir
+				}
+
 				return nil
 			}
 
-			// Get relative path for comparison
-			relPath, err := filepath.Rel(repoDir, path)
-			if err != nil {
-				return nil
+			collectFile(path, info)
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return candidates, skipped, nil
+}
+
+// extractionResult holds the files collected by extractFileContents
+type extractionResult struct {
+	contents map[string]string
+	skipped  []SkippedFile
+	stats    *RepoStats
+	omitted  int             // Files left out because of max_total_bytes
+	outlined map[string]bool // Files reduced to their declarations
+}
+
+// fileCandidate is a file selected for extraction that still has to be read
+type fileCandidate struct {
+	fullPath string
+	relPath  string
+}
+
+// fileResult is the outcome of reading a single candidate file
+type fileResult struct {
+	relPath  string
+	content  string
+	lines    int
+	isBinary bool
+	outlined bool
+	err      error
+}
+
+// readCandidate reads a candidate file, letting the content decide whether it is binary
+func readCandidate(candidate fileCandidate) fileResult {
+	content, isBinary, err := readTextFile(candidate.fullPath)
+	return fileResult{relPath: candidate.relPath, content: content, lines: countLines(content), isBinary: isBinary, err: err}
+}
+
+// countLines returns the number of lines in a text, counting a final line without a newline
+func countLines(content string) int {
+	lines := strings.Count(content, "\n")
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		lines++
+	}
+	return lines
+}
+
+// truncateContent shortens content to at most maxLines lines and maxBytes bytes, where
+// zero means no limit, and appends a marker saying how much was left out. Content is cut
+// on a line boundary unless a single line exceeds maxBytes.
+func truncateContent(content string, maxLines, maxBytes int) (string, bool) {
+	kept := content
+	if maxLines > 0 {
+		end := 0
+		for i := 0; i < maxLines && end < len(kept); i++ {
+			next := strings.IndexByte(kept[end:], '\n')
+			if next < 0 {
+				end = len(kept)
+				break
 			}
+			end += next + 1
+		}
+		kept = kept[:end]
+	}
 
-			// Skip .git files
-			if strings.HasPrefix(relPath, ".git/") || strings.Contains(relPath, "/.git/") {
-				return nil
+	if maxBytes > 0 && len(kept) > maxBytes {
+		end := strings.LastIndexByte(kept[:maxBytes], '\n') + 1
+		if end == 0 {
+			// The first line alone is too long, so cut it without splitting a character
+			end = maxBytes
+			for end > 0 && !utf8.RuneStart(kept[end]) {
+				end--
 			}
+		}
+		kept = kept[:end]
+	}
 
-			// Check if path should be excluded - either exact match or in excluded directory
-			for _, excludePath := range excludePaths {
-				if relPath == excludePath || strings.HasPrefix(relPath, excludePath+"/") {
-					return nil
-				}
+	if len(kept) == len(content) {
+		return content, false
+	}
+
+	rest := content[len(kept):]
+	if kept != "" && !strings.HasSuffix(kept, "\n") {
+		return kept + "\n... [truncated " + pluralize(len(rest), "more byte", "more bytes") + "]", true
+	}
+	return kept + "... [truncated " + pluralize(countLines(rest), "more line", "more lines") + "]", true
+}
+
+// truncate applies the per-file line and byte limits of a request to a file that was read
+func (f *fileResult) truncate(req *RepoRequest) {
+	if content, truncated := truncateContent(f.content, req.MaxFileLines, req.MaxFileBytes); truncated {
+		f.content = content
+		f.lines = countLines(content)
+	}
+}
+
+// readWorkers returns the number of concurrent file readers from the READ_WORKERS env var,
+// defaulting to GOMAXPROCS
+func readWorkers() int {
+	if value := os.Getenv("READ_WORKERS"); value != "" {
+		if workers, err := strconv.Atoi(value); err == nil && workers > 0 {
+			return workers
+		}
+		log.Printf("Warning: Invalid READ_WORKERS %q, using GOMAXPROCS", value)
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// readCandidates reads the candidate files in path order using a bounded number of
+// concurrent readers, returning the contents of text files, their statistics and the files
+// that were skipped. Results are collected on a single goroutine, so the contents map and
+// stats need no locking. Reading stops once the max_total_bytes budget is exhausted.
+func readCandidates(ctx context.Context, candidates []fileCandidate, workers int, req *RepoRequest) *extractionResult {
+	result := &extractionResult{
+		contents: make(map[string]string, len(candidates)),
+		outlined: make(map[string]bool),
+	}
+
+	// Files are read in a stable order, so the files that fit the budget are reproducible
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].relPath < candidates[j].relPath
+	})
+
+	result.stats, result.skipped, result.omitted = processCandidates(ctx, candidates, workers, req, func(file fileResult) {
+		result.contents[file.relPath] = file.content
+		if file.outlined {
+			result.outlined[file.relPath] = true
+		}
+	})
+	return result
+}
+
+// processCandidates reads the candidates in order and passes every file that makes it into
+// the analysis to emit, after outlining, redaction, truncation and the max_total_bytes
+// budget. It returns the statistics of those files, the files that were skipped and the
+// number of files left out because of the budget. With a nil emit the files are only
+// measured, which streamed documents do to write the statistics first, and skipped files
+// are not logged.
+func processCandidates(ctx context.Context, candidates []fileCandidate, workers int, req *RepoRequest, emit func(fileResult)) (*RepoStats, []SkippedFile, int) {
+	stats := newRepoStats()
+	var skipped []SkippedFile
+	redactor := newRedactor(req)
+	budget := newOutputBudget(req)
+	readCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	emitted, omitted := 0, 0
+	streamCandidates(readCtx, candidates, workers, func(file fileResult) {
+		emitted++
+		if file.err != nil {
+			if emit != nil {
+				log.Printf("Warning: Could not read %s: %v", file.relPath, file.err)
 			}
+			skipped = append(skipped, readErrorEntry(file.relPath, file.err))
+			return
+		}
+		if file.isBinary {
+			if emit != nil {
+				log.Printf("Skipping binary file %s", file.relPath)
+			}
+			skipped = append(skipped, SkippedFile{Path: file.relPath, Reason: SkipBinary})
+			return
+		}
 
-			// Skip binary and large files
-			if shouldIgnoreFile(path) || info.Size() > maxFileSize {
-				return nil
+		file.outline(req)
+		file.redact(redactor)
+		file.truncate(req)
+		if !budget.take(len(file.content)) {
+			stop() // Files that were not read yet cannot fit either
+			skipped = append(skipped, SkippedFile{Path: file.relPath, Reason: SkipBudget})
+			omitted++
+			return
+		}
+		stats.add(determineLanguage(file.relPath, req.LanguageOverrides), file.content, file.lines)
+		if emit != nil {
+			emit(file)
+		}
+	})
+
+	if budget.exceeded {
+		omitted += budget.skipRest(candidates[emitted:], &skipped)
+	}
+	return stats, skipped, omitted
+}
+
+// outputBudget tracks how many bytes of file contents may still be included under the
+// max_total_bytes limit of a request
+type outputBudget struct {
+	limit     int
+	remaining int // Negative for no limit
+	exceeded  bool
+}
+
+// newOutputBudget creates the budget of a request
+func newOutputBudget(req *RepoRequest) *outputBudget {
+	if req.MaxTotalBytes <= 0 {
+		return &outputBudget{remaining: -1}
+	}
+	return &outputBudget{limit: req.MaxTotalBytes, remaining: req.MaxTotalBytes}
+}
+
+// take reserves room for a file and reports whether it fits. Once a file does not fit, no
+// later file is included either, so the included files are always a prefix in path order.
+func (b *outputBudget) take(size int) bool {
+	if b.remaining < 0 {
+		return true
+	}
+	if b.exceeded || size > b.remaining {
+		if !b.exceeded {
+			log.Printf("Output budget of %d bytes exhausted, skipping the remaining files", b.limit)
+		}
+		b.exceeded = true
+		return false
+	}
+	b.remaining -= size
+	return true
+}
+
+// skipRest records the candidates that were never read because the budget was exhausted,
+// returning their number
+func (b *outputBudget) skipRest(candidates []fileCandidate, skipped *[]SkippedFile) int {
+	for _, candidate := range candidates {
+		*skipped = append(*skipped, SkippedFile{Path: candidate.relPath, Reason: SkipBudget})
+	}
+	return len(candidates)
+}
+
+// writeBudgetNote notes at the end of a document that files were left out to stay within
+// max_total_bytes
+func writeBudgetNote(w io.Writer, limit, omitted int) {
+	fmt.Fprintf(w, "> Output capped at %d bytes of file contents, %s omitted.\n\n", limit, pluralize(omitted, "file was", "files were"))
+}
+
+// newRepoStats creates empty statistics
+func newRepoStats() *RepoStats {
+	return &RepoStats{Languages: make(map[string]*LanguageStats)}
+}
+
+// sortedLanguages returns the languages of the statistics, largest first
+func (s *RepoStats) sortedLanguages() []string {
+	languages := make([]string, 0, len(s.Languages))
+	for language := range s.Languages {
+		languages = append(languages, language)
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		a, b := s.Languages[languages[i]], s.Languages[languages[j]]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return languages[i] < languages[j]
+	})
+	return languages
+}
+
+// add records a collected file of a language in the overall and per-language statistics
+func (s *RepoStats) add(language, content string, lines int) {
+	if language == "" {
+		language = "other"
+	}
+
+	lang, ok := s.Languages[language]
+	if !ok {
+		lang = &LanguageStats{}
+		s.Languages[language] = lang
+	}
+
+	size := int64(len(content))
+	lang.Files++
+	lang.Lines += lines
+	lang.Bytes += size
+
+	s.TotalFiles++
+	s.TotalLines += lines
+	s.TotalBytes += size
+}
+
+// collectMatchingFiles walks the whole repository and passes every file matched by
+// the pattern, either directly or through a matching parent directory, to collect.
+// Directories ignored by .gitignore rules are not descended into, and the walk stops
+// with the context's error once ctx is done.
+func collectMatchingFiles(ctx context.Context, repoDir, pattern string, ignore *gitignoreMatcher, collect func(string, os.FileInfo)) error {
+	return filepath.Walk(repoDir, func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return nil // Continue to other files
+		}
+
+		if info.IsDir() {
+			// Skip .git directory
+			if info.Name() == ".git" || strings.HasPrefix(info.Name(), ".git") {
+				return filepath.SkipDir
 			}
 
-			// Read file content
-			data, err := ioutil.ReadFile(path)
-			if err != nil {
-				return nil
+			// Skip directories matched by .gitignore rules
+			if relPath, err := filepath.Rel(repoDir, path); err == nil && path != repoDir && ignore.isIgnored(filepath.ToSlash(relPath), true) {
+				return filepath.SkipDir
 			}
+			return nil
+		}
 
-			contents[relPath] = string(data)
+		relPath, err := filepath.Rel(repoDir, path)
+		if err != nil || !matchesPathOrParent(pattern, filepath.ToSlash(relPath)) {
 			return nil
-		})
+		}
+
+		collect(path, info)
+		return nil
+	})
+}
+
+// walkDepth returns the number of directory levels path lies below root, one for the direct
+// children of root
+func walkDepth(root, path string) int {
+	relPath, err := filepath.Rel(root, path)
+	if err != nil {
+		return 0
+	}
+	return strings.Count(filepath.ToSlash(relPath), "/") + 1
+}
+
+// resolvesInsideDir reports whether the parent directories of path, with symbolic links
+// resolved, are dir or lie inside it. Paths whose parents do not exist cannot be read and
+// are accepted.
+func resolvesInsideDir(dir, path string) bool {
+	if filepath.Clean(path) == filepath.Clean(dir) {
+		return true
+	}
+	resolvedDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
 	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return true
+	}
+	return parent == resolvedDir || isInsideDir(resolvedDir, parent)
+}
 
-	return contents, nil
+// isInsideDir reports whether path lies inside dir
+func isInsideDir(dir, path string) bool {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
 }
 
 // cleanupRepo removes the temporary repository directory
 func cleanupRepo(repoDir string) {
+	// Only remove working directories created by this service, never a local_path
+	if !isInsideDir(tempDir, repoDir) {
+		return
+	}
+
 	log.Printf("Cleaning up repository at %s", repoDir)
 	if err := os.RemoveAll(repoDir); err != nil {
 		log.Printf("Failed to remove directory %s: %v", repoDir, err)
@@ -581,16 +2360,19 @@ func cleanupRepo(repoDir string) {
 
 // saveOutputToFile saves the analysis output to a file
 func saveOutputToFile(repoID string, resp *RepoResponse) error {
+	outputs.begin(repoID)
+	defer outputs.end(repoID)
+
 	// Save tree to a text file
 	treeFile := filepath.Join(outputDir, repoID+"_tree.txt")
-	if err := ioutil.WriteFile(treeFile, []byte(resp.Tree), 0644); err != nil {
+	if err := os.WriteFile(treeFile, []byte(resp.Tree), 0644); err != nil {
 		return fmt.Errorf("failed to save tree file: %v", err)
 	}
 	log.Printf("Tree output saved to %s", treeFile)
 
 	// Save markdown to a markdown file
 	mdFile := filepath.Join(outputDir, repoID+"_analysis.md")
-	if err := ioutil.WriteFile(mdFile, []byte(resp.Markdown), 0644); err != nil {
+	if err := os.WriteFile(mdFile, []byte(resp.Markdown), 0644); err != nil {
 		return fmt.Errorf("failed to save markdown file: %v", err)
 	}
 	log.Printf("Markdown output saved to %s", mdFile)
@@ -602,7 +2384,7 @@ func saveOutputToFile(repoID string, resp *RepoResponse) error {
 	}
 
 	jsonFile := filepath.Join(outputDir, repoID+"_response.json")
-	if err := ioutil.WriteFile(jsonFile, jsonData, 0644); err != nil {
+	if err := os.WriteFile(jsonFile, jsonData, 0644); err != nil {
 		return fmt.Errorf("failed to save JSON file: %v", err)
 	}
 	log.Printf("JSON output saved to %s", jsonFile)
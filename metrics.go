@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// cloneDurationBuckets are the histogram buckets in seconds for git clones
+	cloneDurationBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+	// requestDurationBuckets are the histogram buckets in seconds for HTTP requests
+	requestDurationBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+)
+
+// metricsRegistry holds the service metrics in a registry of their own, so only these are
+// served. All methods may be called on a nil registry, which records nothing.
+type metricsRegistry struct {
+	registry *prometheus.Registry
+
+	analyses        *prometheus.CounterVec
+	processedBytes  *prometheus.CounterVec
+	skippedFiles    *prometheus.CounterVec
+	cloneFailures   prometheus.Counter
+	cloneDuration   *prometheus.HistogramVec
+	requestDuration *prometheus.HistogramVec
+}
+
+// metrics is set up in main when METRICS_ENABLED is set, and stays nil otherwise
+var metrics *metricsRegistry
+
+// metricsEnabled reports whether metrics are collected and served, from the METRICS_ENABLED env var
+func metricsEnabled() bool {
+	return os.Getenv("METRICS_ENABLED") == "true"
+}
+
+// newMetricsRegistry creates a registry with every service metric
+func newMetricsRegistry() *metricsRegistry {
+	m := &metricsRegistry{
+		registry: prometheus.NewRegistry(),
+		analyses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gitdump_analyses_total",
+			Help: "Repository analyses by format and result.",
+		}, []string{"format", "result"}),
+		processedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gitdump_processed_bytes_total",
+			Help: "Bytes of file contents included in analyses.",
+		}, []string{"format"}),
+		skippedFiles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gitdump_skipped_files_total",
+			Help: "Files left out of analyses by reason.",
+		}, []string{"reason"}),
+		cloneFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gitdump_clone_failures_total",
+			Help: "Failed git clones.",
+		}),
+		cloneDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gitdump_clone_duration_seconds",
+			Help:    "Duration of git clones by result.",
+			Buckets: cloneDurationBuckets,
+		}, []string{"result"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gitdump_request_duration_seconds",
+			Help:    "Duration of HTTP requests by format and result.",
+			Buckets: requestDurationBuckets,
+		}, []string{"format", "result"}),
+	}
+	m.registry.MustRegister(m.analyses, m.processedBytes, m.skippedFiles, m.cloneFailures, m.cloneDuration, m.requestDuration)
+	return m
+}
+
+// resultLabel returns the result label for an outcome
+func resultLabel(failed bool) string {
+	if failed {
+		return "failure"
+	}
+	return "success"
+}
+
+// metricsFormat maps a requested format to one of the formats the service knows, so clients
+// cannot create arbitrary series
+func metricsFormat(format string) string {
+	switch format {
+	case "json", "jsonl", "xml", "html", "zip", "batch", "job":
+		return format
+	case "text", "txt":
+		return "text"
+	default: // markdown or any other value defaults to markdown
+		return "markdown"
+	}
+}
+
+// requestFormat returns the format label of an HTTP request. The label is derived from the
+// route pattern, and requests of endpoints that return no analysis are labeled "none".
+func requestFormat(r *http.Request) string {
+	switch {
+	case strings.HasSuffix(r.Pattern, "/analyze/batch"):
+		return "batch"
+	case r.Pattern == "POST /jobs":
+		return "job"
+	case r.Pattern == "/preview":
+		return "preview"
+	case strings.Contains(r.Pattern, "/analyze"), strings.HasSuffix(r.Pattern, "/result"):
+		return metricsFormat(r.URL.Query().Get("format"))
+	default:
+		return "none"
+	}
+}
+
+// recordAnalysis counts a finished analysis and the bytes of file contents it included
+func (m *metricsRegistry) recordAnalysis(format string, stats *RepoStats, err error) {
+	if m == nil {
+		return
+	}
+
+	format = metricsFormat(format)
+	m.analyses.WithLabelValues(format, resultLabel(err != nil)).Inc()
+	if stats != nil {
+		m.processedBytes.WithLabelValues(format).Add(float64(stats.TotalBytes))
+	}
+}
+
+// recordSkipped counts the files left out of an analysis by reason
+func (m *metricsRegistry) recordSkipped(skipped []SkippedFile) {
+	if m == nil {
+		return
+	}
+	for _, file := range skipped {
+		m.skippedFiles.WithLabelValues(string(file.Reason)).Inc()
+	}
+}
+
+// recordClone records the duration and outcome of a git clone
+func (m *metricsRegistry) recordClone(duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.cloneDuration.WithLabelValues(resultLabel(err != nil)).Observe(duration.Seconds())
+	if err != nil {
+		m.cloneFailures.Inc()
+	}
+}
+
+// recordRequest records the duration of an HTTP request by its format and result
+func (m *metricsRegistry) recordRequest(r *http.Request, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requestDuration.WithLabelValues(requestFormat(r), resultLabel(status >= http.StatusBadRequest)).Observe(duration.Seconds())
+}
+
+// handler serves the collected metrics in the Prometheus text format
+func (m *metricsRegistry) handler() http.HandlerFunc {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}).ServeHTTP
+}
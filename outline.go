@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"log"
+)
+
+// outliners reduce the content of a file to its declarations, keyed by the language
+// returned by determineLanguage. Languages without an outliner are included in full, so
+// supporting another language only takes an entry here.
+var outliners = map[string]func(path, content string) (string, error){
+	"go": outlineGo,
+}
+
+// outline replaces the content of a file with its outline when the request asks for
+// outlines and the language of the file has an outliner. Files that cannot be parsed are
+// kept in full.
+func (f *fileResult) outline(req *RepoRequest) {
+	if !req.Outline {
+		return
+	}
+	outliner, ok := outliners[determineLanguage(f.relPath, req.LanguageOverrides)]
+	if !ok {
+		return
+	}
+
+	content, err := outliner(f.relPath, f.content)
+	if err != nil {
+		log.Printf("Warning: Could not outline %s, including it in full: %v", f.relPath, err)
+		return
+	}
+	f.content = content
+	f.lines = countLines(content)
+	f.outlined = true
+}
+
+// outlineGo returns the package clause, imports and top-level declarations of a Go file
+// with their doc comments. Function bodies are left out, as are the bodies of function
+// literals in variable declarations.
+func outlineGo(path, content string) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, content, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return "", err
+	}
+
+	// Comments are kept only if the node they belong to is still part of the outline
+	comments := ast.NewCommentMap(fset, file, file.Comments)
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			decl.Body = nil
+		case *ast.GenDecl:
+			ast.Inspect(decl, func(node ast.Node) bool {
+				if lit, ok := node.(*ast.FuncLit); ok {
+					lit.Body = &ast.BlockStmt{}
+					return false
+				}
+				return true
+			})
+		}
+	}
+	file.Comments = comments.Filter(file).Comments()
+
+	var buf bytes.Buffer
+	config := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if err := config.Fprint(&buf, fset, file); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOutputTTL      = 24 * time.Hour   // How long output files are kept when OUTPUT_TTL is not set
+	outputCleanupInterval = 10 * time.Minute // How often expired output files are removed
+)
+
+// outputStore tracks the analysis files persisted to the output directory and removes
+// them once they expire. Files of repositories that are still being written are kept.
+type outputStore struct {
+	mu      sync.Mutex
+	dir     string
+	ttl     time.Duration
+	writing map[string]int // Number of in-flight writers per repo ID
+}
+
+// outputs persists analysis files when PERSIST_OUTPUT is enabled, it is set up in main
+// and stays nil when output files are not written at all
+var outputs *outputStore
+
+// newOutputStore creates a store for the output files in dir that expire after ttl
+func newOutputStore(dir string, ttl time.Duration) *outputStore {
+	return &outputStore{
+		dir:     dir,
+		ttl:     ttl,
+		writing: make(map[string]int),
+	}
+}
+
+// persistOutput reports whether analysis files should be written, from the PERSIST_OUTPUT env var
+func persistOutput() bool {
+	return os.Getenv("PERSIST_OUTPUT") == "true"
+}
+
+// outputTTL returns the retention time for output files from the OUTPUT_TTL env var
+func outputTTL() time.Duration {
+	if value := os.Getenv("OUTPUT_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err == nil && ttl > 0 {
+			return ttl
+		}
+		log.Printf("Warning: Invalid OUTPUT_TTL %q, using default of %v", value, defaultOutputTTL)
+	}
+	return defaultOutputTTL
+}
+
+// begin marks the output files of a repository as being written, so they are not removed
+// by a concurrent cleanup. Every call must be followed by a call to end.
+func (s *outputStore) begin(repoID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writing[repoID]++
+}
+
+// end marks the output files of a repository as complete
+func (s *outputStore) end(repoID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.writing[repoID]--; s.writing[repoID] <= 0 {
+		delete(s.writing, repoID)
+	}
+}
+
+// outputRepoID returns the repo ID an output file name belongs to
+func outputRepoID(name string) string {
+	if i := strings.Index(name, "_"); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// isExpired reports whether a file last modified at modTime has outlived the TTL
+func (s *outputStore) isExpired(modTime, now time.Time) bool {
+	return now.Sub(modTime) > s.ttl
+}
+
+// cleanup removes output files that were last modified before the TTL and are not being
+// written. The lock is held throughout, so no writer can start on a file being removed.
+func (s *outputStore) cleanup(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		log.Printf("Warning: Failed to read output directory: %v", err)
+		return
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || s.writing[outputRepoID(entry.Name())] > 0 {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !s.isExpired(info.ModTime(), now) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, entry.Name())); err != nil {
+			log.Printf("Warning: Failed to remove expired output file %s: %v", entry.Name(), err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("Removed %d expired output files", removed)
+	}
+}
+
+// startCleanup periodically removes expired output files in a background goroutine
+func (s *outputStore) startCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			s.cleanup(now)
+		}
+	}()
+}
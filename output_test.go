@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOutputStoreIsExpired(t *testing.T) {
+	s := newOutputStore(t.TempDir(), time.Hour)
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		modTime time.Time
+		want    bool
+	}{
+		{"fresh", now.Add(-time.Minute), false},
+		{"exactly the TTL", now.Add(-time.Hour), false},
+		{"past the TTL", now.Add(-time.Hour - time.Second), true},
+		{"modified in the future", now.Add(time.Minute), false},
+	}
+
+	for _, tt := range tests {
+		if got := s.isExpired(tt.modTime, now); got != tt.want {
+			t.Errorf("%s: isExpired() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOutputStoreCleanup(t *testing.T) {
+	dir := t.TempDir()
+	s := newOutputStore(dir, time.Hour)
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+
+	// writeOutput creates an output file last modified at modTime
+	writeOutput := func(name string, modTime time.Time) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("output"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeOutput("expired_tree.txt", old)
+	writeOutput("expired_analysis.md", old)
+	writeOutput("fresh_tree.txt", now.Add(-time.Minute))
+	writeOutput("writing_tree.txt", old)
+	writeOutput("writing_analysis.md", old)
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "subdir"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files of a repository that is still written are kept even once they are expired
+	s.begin("writing")
+	s.begin("writing")
+	s.end("writing")
+	s.cleanup(now)
+
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+	for name, want := range map[string]bool{
+		"expired_tree.txt":    false,
+		"expired_analysis.md": false,
+		"fresh_tree.txt":      true,
+		"writing_tree.txt":    true,
+		"writing_analysis.md": true,
+		"subdir":              true,
+	} {
+		if got := exists(name); got != want {
+			t.Errorf("after cleanup %s exists = %v, want %v", name, got, want)
+		}
+	}
+
+	// Once the last writer is done the files expire like any other
+	s.end("writing")
+	s.cleanup(now)
+	for _, name := range []string{"writing_tree.txt", "writing_analysis.md"} {
+		if exists(name) {
+			t.Errorf("%s was kept after its writer finished", name)
+		}
+	}
+	if len(s.writing) != 0 {
+		t.Errorf("writing still tracks %v", s.writing)
+	}
+}
+
+func TestOutputRepoID(t *testing.T) {
+	tests := map[string]string{
+		"1838918d0b88-98c437b3e5e3eaac_tree.txt":      "1838918d0b88-98c437b3e5e3eaac",
+		"1838918d0b88-98c437b3e5e3eaac_response.json": "1838918d0b88-98c437b3e5e3eaac",
+		"notes.txt": "notes.txt",
+	}
+	for name, want := range tests {
+		if got := outputRepoID(name); got != want {
+			t.Errorf("outputRepoID(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// PreviewResponse is the JSON response of /preview
+type PreviewResponse struct {
+	Repository string `json:"repository"`
+	Tree       string `json:"tree"`
+	RepoID     string `json:"repo_id,omitempty"` // ID of the checkout, like the repo_id of an analysis
+}
+
+// handlePreview checks out a repository and returns only its directory tree, as plain text
+// or with format=json as JSON. No file is read, so callers can pick the dirs to analyze
+// quickly. It accepts the same request body and query parameters as /analyze.
+func handlePreview(w http.ResponseWriter, r *http.Request) {
+	var req RepoRequest
+
+	switch r.Method {
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Printf("Error parsing request body: %v", err)
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+
+	case http.MethodGet:
+		var err error
+		if req, err = requestFromQuery(r.URL.Query()); err != nil {
+			log.Printf("Invalid query parameters: %v", err)
+			writeJSONError(w, errorStatus(err), err.Error())
+			return
+		}
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if err := validateRequest(&req); err != nil {
+		log.Printf("Invalid request: %v", err)
+		writeJSONError(w, errorStatus(err), err.Error())
+		return
+	}
+	req.trusted = isTrustedCaller(r)
+
+	// Previews still clone, so they share the slots of the analyses
+	release, ok := acquireAnalysisSlot(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), analyzeTimeout())
+	defer cancel()
+
+	repoID, tree, err := previewTree(ctx, &req)
+	if err != nil {
+		log.Printf("Preview failed: %v", err)
+		writeJSONError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set(repoIDHeader, repoID)
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PreviewResponse{Repository: req.repoName(), Tree: tree, RepoID: repoID})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, tree)
+}
+
+// previewTree checks out a repository, shallowly unless the request asks for a depth, and
+// generates its directory tree. It returns the ID of the checkout and the tree.
+func previewTree(ctx context.Context, req *RepoRequest) (string, string, error) {
+	// The tree shows the layout of the repository itself, so neither its submodules nor
+	// the diff base are fetched
+	preview := *req
+	preview.Submodules = false
+	preview.DiffBase = ""
+
+	repoID, repoDir, err := checkoutRepo(ctx, &preview)
+	defer cleanupRepo(repoDir) // Clean up after processing
+	if err != nil {
+		return repoID, "", err
+	}
+
+	tree, err := generateDirectoryTree(ctx, repoDir)
+	if ctx.Err() != nil {
+		return repoID, "", contextError(ctx)
+	}
+	if err != nil {
+		return repoID, "", &requestError{http.StatusInternalServerError, "Failed to generate directory tree: " + err.Error()}
+	}
+	return repoID, tree, nil
+}
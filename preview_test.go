@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPreviewTreeSkipsSubmodulesAndDiffBase(t *testing.T) {
+	repo := newTestRepo(t, map[string]string{
+		"main.go": "package main\n",
+		// Fetching this submodule fails, since its host is not allowed
+		".gitmodules": "[submodule \"lib\"]\n\tpath = lib\n\turl = https://git.invalid/lib.git\n",
+	})
+	chdirTemp(t)
+
+	// Neither the submodule nor the diff base, which does not exist, is fetched
+	req := &RepoRequest{RepoURL: repo, Submodules: true, DiffBase: strings.Repeat("0", 40)}
+	repoID, tree, err := previewTree(context.Background(), req)
+	if err != nil {
+		t.Fatalf("previewTree failed: %v", err)
+	}
+	if repoID == "" {
+		t.Error("previewTree returned no repo ID")
+	}
+	if !strings.Contains(tree, "main.go") {
+		t.Errorf("tree is missing main.go:\n%s", tree)
+	}
+	if !req.Submodules || req.DiffBase == "" {
+		t.Error("previewTree changed the request")
+	}
+
+	// The same checkout for an analysis does fetch them and fails
+	_, repoDir, err := checkoutRepo(context.Background(), req)
+	defer cleanupRepo(repoDir)
+	if err == nil {
+		t.Error("checkout with the submodule and diff base succeeded")
+	}
+}
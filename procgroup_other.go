@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// killProcessGroupOnCancel keeps the default behavior of killing only the command itself
+// on platforms without process groups
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
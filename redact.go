@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	redactedPlaceholder = "[REDACTED]"
+	maxRedactPatterns   = 50 // Maximum number of redact_patterns in a request
+	maxSkipFiles        = 50 // Maximum number of skip_files in a request
+)
+
+// secretPattern is a built-in pattern for secrets. Replacement keeps the parts of a match
+// that are not secret, such as the name of an assignment.
+type secretPattern struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// defaultSecretPatterns are the secrets redacted when a request sets redact
+var defaultSecretPatterns = []secretPattern{
+	// PEM encoded private keys, the whole block is replaced
+	{regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`), redactedPlaceholder},
+	// AWS access key IDs
+	{regexp.MustCompile(`\b(AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`), redactedPlaceholder},
+	// GitHub tokens
+	{regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`), redactedPlaceholder},
+	// Slack tokens
+	{regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`), redactedPlaceholder},
+	// Assignments of keys, secrets, tokens and passwords in .env files, shell scripts and
+	// YAML or JSON configuration such as API_KEY=... or "client_secret": "..."
+	{
+		regexp.MustCompile(`(?im)^(\s*(?:export\s+)?["']?[\w.-]*(?:api[_-]?key|secret|token|passwd|password|access[_-]?key)[\w.-]*["']?\s*[=:]\s*)["']?[^\s"'=#,{}\[\]][^\s"',]*["']?`),
+		"${1}" + redactedPlaceholder,
+	},
+}
+
+// defaultSensitiveFiles are the file name patterns skipped entirely when a request sets redact
+var defaultSensitiveFiles = []string{
+	".env", ".env.*", "*.pem", "*.key", "*.p12", "*.pfx", "*.keystore", "*.jks",
+	"id_rsa", "id_dsa", "id_ecdsa", "id_ed25519", ".netrc", ".npmrc", ".pypirc",
+}
+
+// redactor replaces secrets in file contents before they are added to an analysis
+type redactor struct {
+	patterns []secretPattern
+}
+
+// newRedactor compiles the redaction patterns of a validated request. It returns nil when
+// the request does not ask for redaction.
+func newRedactor(req *RepoRequest) *redactor {
+	if !req.Redact && len(req.RedactPatterns) == 0 {
+		return nil
+	}
+
+	r := &redactor{}
+	if req.Redact {
+		r.patterns = append(r.patterns, defaultSecretPatterns...)
+	}
+	for _, pattern := range req.RedactPatterns {
+		r.patterns = append(r.patterns, secretPattern{regexp.MustCompile(pattern), redactedPlaceholder})
+	}
+	return r
+}
+
+// redact replaces every secret in content, returning the redacted content and the number
+// of secrets that were replaced
+func (r *redactor) redact(content string) (string, int) {
+	if r == nil {
+		return content, 0
+	}
+
+	count := 0
+	for _, pattern := range r.patterns {
+		matches := len(pattern.re.FindAllStringIndex(content, -1))
+		if matches == 0 {
+			continue
+		}
+		count += matches
+		content = pattern.re.ReplaceAllString(content, pattern.replacement)
+	}
+	return content, count
+}
+
+// validateContentFilters checks the redaction patterns and the file name patterns of a request
+func validateContentFilters(req *RepoRequest) error {
+	if len(req.RedactPatterns) > maxRedactPatterns {
+		return fmt.Errorf("at most %d redact_patterns may be specified", maxRedactPatterns)
+	}
+	for _, pattern := range req.RedactPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid redact pattern %q: %v", pattern, err)
+		}
+		if re.MatchString("") {
+			return fmt.Errorf("redact pattern %q matches empty text", pattern)
+		}
+	}
+
+	if len(req.SkipFiles) > maxSkipFiles {
+		return fmt.Errorf("at most %d skip_files may be specified", maxSkipFiles)
+	}
+	for _, pattern := range req.SkipFiles {
+		if pattern == "" || strings.Contains(pattern, "/") {
+			return fmt.Errorf("invalid skip_files pattern %q: expected a file name pattern", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid skip_files pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// isSensitiveFile reports whether the name of a file matches one of the skip_files patterns
+// of a request, or one of the default sensitive file names when it sets redact
+func isSensitiveFile(relPath string, req *RepoRequest) bool {
+	name := path.Base(relPath)
+	for _, pattern := range req.SkipFiles {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	if !req.Redact {
+		return false
+	}
+	for _, pattern := range defaultSensitiveFiles {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// redact replaces the secrets in a file that was read
+func (f *fileResult) redact(r *redactor) {
+	content, count := r.redact(f.content)
+	if count == 0 {
+		return
+	}
+	log.Printf("Redacted %s in %s", pluralize(count, "secret", "secrets"), f.relPath)
+	f.content = content
+	f.lines = countLines(content)
+}
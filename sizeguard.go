@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRepoSizeKB = 1024 * 1024 // 1GB limit for cloned repositories
+	githubAPITimeout     = 10 * time.Second
+)
+
+// errRepoTooLarge is returned when a repository exceeds the configured size limit
+var errRepoTooLarge = errors.New("repository too large")
+
+// isTrustedCaller reports whether a request carries the TRUSTED_CALLER_TOKEN, which
+// allows it to raise limits such as the maximum repository size
+func isTrustedCaller(r *http.Request) bool {
+	token := os.Getenv("TRUSTED_CALLER_TOKEN")
+	if token == "" {
+		return false
+	}
+	provided := r.Header.Get("X-Trusted-Caller")
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// repoSizeLimitKB returns the maximum repository size in KB for a request, or 0 for no limit.
+// Any caller may lower the limit, only trusted callers may raise it.
+func repoSizeLimitKB(req *RepoRequest) int64 {
+	limit := int64(defaultMaxRepoSizeKB)
+	if value := os.Getenv("MAX_REPO_SIZE_KB"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err == nil && parsed >= 0 {
+			limit = parsed
+		} else {
+			log.Printf("Warning: Invalid MAX_REPO_SIZE_KB %q, using default of %d", value, limit)
+		}
+	}
+
+	if req.MaxRepoSizeKB > 0 && (req.trusted || limit == 0 || req.MaxRepoSizeKB < limit) {
+		limit = req.MaxRepoSizeKB
+	}
+
+	return limit
+}
+
+// githubRepoPath returns "owner/repo" for github.com repository URLs
+func githubRepoPath(repoURL string) (string, bool) {
+	parsedURL, err := parseRepoURL(repoURL)
+	if err != nil {
+		return "", false
+	}
+
+	host := strings.ToLower(parsedURL.Hostname())
+	if host != "github.com" && host != "www.github.com" {
+		return "", false
+	}
+
+	parts := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")
+	if len(parts) < 2 {
+		return "", false
+	}
+	return parts[0] + "/" + strings.TrimSuffix(parts[1], ".git"), true
+}
+
+// requestToken returns the access token a request authenticates with, if any
+func requestToken(req *RepoRequest) string {
+	if req.Auth != nil && req.Auth.Type == "token" {
+		return req.Auth.Token
+	}
+	if req.IsPrivate {
+		return os.Getenv("GITHUB_TOKEN")
+	}
+	return ""
+}
+
+// checkGitHubRepoSize looks up the size of a github.com repository through the GitHub API
+// before cloning. It reports whether the size could be determined, and returns
+// errRepoTooLarge if it exceeds the limit.
+func checkGitHubRepoSize(ctx context.Context, req *RepoRequest, limitKB int64) (bool, error) {
+	repoPath, ok := githubRepoPath(req.RepoURL)
+	if !ok {
+		return false, nil
+	}
+
+	apiURL := os.Getenv("GITHUB_API_URL")
+	if apiURL == "" {
+		apiURL = "https://api.github.com"
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(apiURL, "/")+"/repos/"+repoPath, nil)
+	if err != nil {
+		return false, err
+	}
+	httpReq.Header.Set("Accept", "application/vnd.github+json")
+	if token := requestToken(req); token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: githubAPITimeout}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+	}
+
+	var repo struct {
+		Size int64 `json:"size"` // Size in KB
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&repo); err != nil {
+		return false, fmt.Errorf("failed to decode GitHub API response: %v", err)
+	}
+
+	log.Printf("GitHub reports a size of %d KB for %s", repo.Size, repoPath)
+	if repo.Size > limitKB {
+		return true, fmt.Errorf("%w: %d KB exceeds the limit of %d KB", errRepoTooLarge, repo.Size, limitKB)
+	}
+	return true, nil
+}
+
+// directorySizeKB returns the total size of the files in a directory in KB
+func directorySizeKB(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Continue despite errors
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return (size + 1023) / 1024, err
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// isStreamedFormat reports whether a format is written while files are read instead of
+// being assembled in memory first. Only formats that need the whole analysis are buffered.
+func isStreamedFormat(format string) bool {
+	switch format {
+	case "json", "xml", "html", "zip":
+		return false
+	default: // markdown, text, jsonl and any other value that defaults to markdown
+		return true
+	}
+}
+
+// isDocumentFormat reports whether a format is the markdown or text document, which can be
+// served from the streamed markdown document alone
+func isDocumentFormat(format string) bool {
+	return isStreamedFormat(format) && format != "jsonl"
+}
+
+// tokenCounter is a writer that estimates the tokens of everything written through it
+type tokenCounter struct {
+	w      io.Writer
+	tokens int
+}
+
+// Write estimates the tokens of p and passes it on to the underlying writer
+func (c *tokenCounter) Write(p []byte) (int, error) {
+	c.tokens += estimateTokens(string(p))
+	return c.w.Write(p)
+}
+
+// streamAnalysis clones a repository and writes its markdown or text document directly to
+// the response as files are read, so neither the whole document nor all file contents are
+// held in memory. Errors before the response is started are returned so the caller can
+// report them; later errors can only be logged. When cacheKey is set, a copy of the document
+// is kept and cached once it is complete. It returns the statistics of the files written.
+func streamAnalysis(ctx context.Context, w http.ResponseWriter, req *RepoRequest, format, cacheKey string) (*RepoStats, error) {
+	repoID, repoDir, tree, candidates, skipped, err := prepareStream(ctx, req)
+	defer cleanupRepo(repoDir) // Clean up after processing
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Set(repoIDHeader, repoID)
+	repoName := req.repoName()
+	extension := "md"
+	if format == "text" || format == "txt" {
+		w.Header().Set("Content-Type", "text/plain")
+		extension = "txt"
+	} else {
+		w.Header().Set("Content-Type", "text/markdown")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-analysis.%s", repoName, extension))
+
+	// Keep a copy of the document in the output directory, like the buffered formats do
+	var out io.Writer = w
+	if outputs != nil {
+		outputs.begin(repoID)
+		defer outputs.end(repoID)
+
+		if err := os.WriteFile(filepath.Join(outputDir, repoID+"_tree.txt"), []byte(tree), 0644); err != nil {
+			log.Printf("Warning: Failed to save tree file: %v", err)
+		}
+		outputFile, err := os.Create(filepath.Join(outputDir, repoID+"_analysis."+extension))
+		if err != nil {
+			log.Printf("Warning: Failed to save output to file: %v", err)
+		} else {
+			defer outputFile.Close()
+			out = io.MultiWriter(w, outputFile)
+		}
+	}
+
+	// Keep a copy of the document for the cache, so repeated requests are not cloned again
+	var document strings.Builder
+	if cacheKey != "" {
+		out = io.MultiWriter(out, &document)
+	}
+
+	text := extension == "txt"
+	stats, readSkipped, err := writeStreamedDocument(ctx, out, text, tree, candidates, req, nil)
+	skipped = append(skipped, readSkipped...)
+	metrics.recordSkipped(skipped)
+	if err != nil {
+		return stats, err
+	}
+	if ctx.Err() != nil {
+		// The response has already started, so the truncated document can only be logged
+		log.Printf("Error: Streaming aborted after %d files: %v", stats.TotalFiles, ctx.Err())
+		return stats, nil
+	}
+	if stats.TotalFiles == 0 {
+		log.Printf("Warning: No file contents were collected, %d files were skipped", len(skipped))
+	}
+
+	if cacheKey != "" {
+		markdown := document.String()
+		if text {
+			// writeResponse derives the text document from the markdown one
+			markdown = "# Repository Analysis\n\n" + strings.TrimPrefix(markdown, "# Directory Tree\n\n"+tree+"\n\n# File Contents\n\n")
+		}
+		cache.put(documentKey(cacheKey), &RepoResponse{
+			Tree:     tree,
+			Markdown: markdown,
+			Skipped:  skipped,
+			Stats:    stats,
+			RepoID:   repoID,
+		})
+	}
+
+	return stats, nil
+}
+
+// prepareStream checks out a repository and collects the files to stream, sorted by path.
+// The caller must clean up repoDir, even when an error is returned.
+func prepareStream(ctx context.Context, req *RepoRequest) (repoID, repoDir, tree string, candidates []fileCandidate, skipped []SkippedFile, err error) {
+	repoID, repoDir, err = checkoutRepo(ctx, req)
+	if err != nil {
+		return repoID, repoDir, "", nil, nil, err
+	}
+
+	log.Printf("Analyzing repository...")
+	tree, err = generateDirectoryTree(ctx, repoDir)
+	if ctx.Err() != nil {
+		return repoID, repoDir, "", nil, nil, contextError(ctx)
+	}
+	if err != nil {
+		return repoID, repoDir, "", nil, nil, &requestError{http.StatusInternalServerError, "Failed to analyze repository: failed to generate directory tree: " + err.Error()}
+	}
+
+	// Collect and sort the paths first so the files can be streamed in a stable order
+	candidates, skipped, err = collectCandidates(ctx, repoDir, req)
+	if ctx.Err() != nil {
+		return repoID, repoDir, "", nil, nil, contextError(ctx)
+	}
+	if err != nil {
+		return repoID, repoDir, "", nil, nil, &requestError{http.StatusInternalServerError, "Failed to analyze repository: failed to collect files: " + err.Error()}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].relPath < candidates[j].relPath
+	})
+	return repoID, repoDir, tree, candidates, skipped, nil
+}
+
+// writeStreamedDocument reads the candidates and writes the markdown document (or the text
+// document when text is set) to out as they are read. Every file is read once, so the
+// statistics are only known at the end and follow the file contents. Nothing is written
+// until the first file has been read, and if the analysis is cancelled before that the
+// context error is returned so the caller can still report it. onFile, if not nil, is called
+// for every file that made it into the document. It returns the statistics and the files
+// that were skipped because they could not be read, turned out to be binary or exceeded
+// the budget.
+func writeStreamedDocument(ctx context.Context, out io.Writer, text bool, tree string, candidates []fileCandidate, req *RepoRequest, onFile func(fileResult)) (*RepoStats, []SkippedFile, error) {
+	counter := &tokenCounter{w: out}
+	doc := io.Writer(out)
+	if req.CountTokens {
+		doc = counter
+	}
+
+	started := false
+	start := func() {
+		started = true
+		title := "Repository Analysis"
+		if text {
+			// The text format starts with the plain directory tree
+			io.WriteString(doc, "# Directory Tree\n\n"+tree+"\n\n")
+			title = "File Contents"
+		}
+		writeMarkdownHeader(doc, title, tree, nil, req)
+	}
+
+	fileTokens := make(map[string]int)
+	stats, skipped, omitted := processCandidates(ctx, candidates, readWorkers(), req, func(file fileResult) {
+		if !started {
+			start()
+		}
+		if req.CountTokens {
+			fileTokens[file.relPath] = estimateTokens(file.content)
+		}
+		writeMarkdownFile(doc, file.relPath, file.content, file.outlined, req.LanguageOverrides)
+		if onFile != nil {
+			onFile(file)
+		}
+	})
+	if ctx.Err() != nil {
+		if !started {
+			return stats, skipped, contextError(ctx)
+		}
+		return stats, skipped, nil
+	}
+	if !started {
+		start()
+	}
+
+	io.WriteString(doc, "---\n\n")
+	if omitted > 0 {
+		writeBudgetNote(doc, req.MaxTotalBytes, omitted)
+	}
+	io.WriteString(doc, generateStatsTable(stats))
+
+	if req.CountTokens {
+		io.WriteString(out, generateTokenSummary(counter.tokens, fileTokens))
+	}
+	return stats, skipped, nil
+}
+
+// streamCandidates reads candidate files concurrently and passes them to emit in the order
+// of candidates. At most workers files are read ahead, which bounds memory usage.
+func streamCandidates(ctx context.Context, candidates []fileCandidate, workers int, emit func(fileResult)) {
+	pending := make(chan chan fileResult, workers)
+
+	go func() {
+		for _, candidate := range candidates {
+			if ctx.Err() != nil {
+				break // Stop reading once the analysis is cancelled
+			}
+			result := make(chan fileResult, 1)
+			pending <- result // Blocks while too many files are waiting to be emitted
+
+			go func(candidate fileCandidate) {
+				result <- readCandidate(candidate)
+			}(candidate)
+		}
+		close(pending)
+	}()
+
+	for result := range pending {
+		emit(<-result)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// benchmarkFileCount is the number of files in the synthetic tree of the benchmarks
+const benchmarkFileCount = 5000
+
+// newBenchmarkTree writes a tree of small source files spread over nested directories and
+// returns them as candidates
+func newBenchmarkTree(b *testing.B) []fileCandidate {
+	b.Helper()
+	dir := b.TempDir()
+	content := []byte(strings.Repeat("func example() int {\n\treturn 42\n}\n\n", 50))
+
+	candidates := make([]fileCandidate, 0, benchmarkFileCount)
+	for i := 0; i < benchmarkFileCount; i++ {
+		relPath := fmt.Sprintf("pkg%02d/sub%02d/file%04d.go", i%50, i%7, i)
+		fullPath := filepath.Join(dir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, content, 0644); err != nil {
+			b.Fatal(err)
+		}
+		candidates = append(candidates, fileCandidate{fullPath: fullPath, relPath: relPath})
+	}
+	return candidates
+}
+
+// writeCandidates writes files to a temporary directory and returns them as candidates sorted by path
+func writeCandidates(t *testing.T, files map[string]string) []fileCandidate {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestFiles(t, dir, files)
+
+	relPaths := make([]string, 0, len(files))
+	for relPath := range files {
+		relPaths = append(relPaths, relPath)
+	}
+	candidates := make([]fileCandidate, 0, len(files))
+	for _, relPath := range sortedStrings(relPaths) {
+		candidates = append(candidates, fileCandidate{fullPath: filepath.Join(dir, filepath.FromSlash(relPath)), relPath: relPath})
+	}
+	return candidates
+}
+
+func TestWriteStreamedDocumentStatistics(t *testing.T) {
+	candidates := writeCandidates(t, map[string]string{
+		"main.go":      "package main\n\nfunc main() {}\n",
+		"docs/note.md": "# Note\n",
+	})
+
+	var out bytes.Buffer
+	stats, _, err := writeStreamedDocument(context.Background(), &out, false, "tree", candidates, &RepoRequest{}, nil)
+	if err != nil {
+		t.Fatalf("writeStreamedDocument() error = %v", err)
+	}
+	if stats.TotalFiles != 2 {
+		t.Errorf("TotalFiles = %d, want 2", stats.TotalFiles)
+	}
+
+	// The statistics are only known once every file was read, so they follow the contents
+	doc := out.String()
+	if count := strings.Count(doc, "## Statistics"); count != 1 {
+		t.Fatalf("document has %d statistics sections, want 1:\n%s", count, doc)
+	}
+	if strings.Index(doc, "## Statistics") < strings.Index(doc, "### main.go") {
+		t.Errorf("statistics come before the file contents:\n%s", doc)
+	}
+	if !strings.Contains(doc, "| **Total** | 2 | 4 |") {
+		t.Errorf("statistics do not count both files:\n%s", doc)
+	}
+}
+
+func TestWriteStreamedDocumentCancelled(t *testing.T) {
+	candidates := writeCandidates(t, map[string]string{"main.go": "package main\n"})
+
+	tests := []struct {
+		name   string
+		ctx    func() context.Context
+		status int
+	}{
+		{"cancelled", func() context.Context {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx
+		}, http.StatusServiceUnavailable},
+		{"timed out", func() context.Context {
+			ctx, cancel := context.WithTimeout(context.Background(), 0)
+			t.Cleanup(cancel)
+			return ctx
+		}, http.StatusGatewayTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, text := range []bool{false, true} {
+				var out bytes.Buffer
+				_, _, err := writeStreamedDocument(tt.ctx(), &out, text, "tree", candidates, &RepoRequest{}, nil)
+				if status := errorStatus(err); status != tt.status {
+					t.Errorf("text %v: status = %d, want %d (error %v)", text, status, tt.status, err)
+				}
+				// Nothing may be written, so the caller can still send the error status
+				if out.Len() != 0 {
+					t.Errorf("text %v: wrote %d bytes before failing", text, out.Len())
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReadCandidates(b *testing.B) {
+	candidates := newBenchmarkTree(b)
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				result := readCandidates(context.Background(), candidates, workers, &RepoRequest{})
+				if len(result.contents) != benchmarkFileCount {
+					b.Fatalf("read %d files, want %d", len(result.contents), benchmarkFileCount)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkStreamCandidates(b *testing.B) {
+	candidates := newBenchmarkTree(b)
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				files := 0
+				streamCandidates(context.Background(), candidates, workers, func(file fileResult) {
+					if file.err != nil {
+						b.Fatal(file.err)
+					}
+					files++
+				})
+				if files != benchmarkFileCount {
+					b.Fatalf("streamed %d files, want %d", files, benchmarkFileCount)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const maxSubmoduleDepth = 5 // Levels of nested submodules that are fetched
+
+// submodule is an entry of a .gitmodules file
+type submodule struct {
+	name string
+	path string
+	url  string
+}
+
+// updateSubmodules fetches the submodules of a checked out repository, one level at a time
+// so the URLs of nested submodules can be checked before anything is fetched from them.
+// Submodules are fetched with the credentials of the parent repository.
+func updateSubmodules(ctx context.Context, auth *gitAuth, repoDir string, level int) error {
+	modules, err := readGitmodules(ctx, auth, repoDir)
+	if err != nil || len(modules) == 0 {
+		return err
+	}
+	if level > maxSubmoduleDepth {
+		log.Printf("Warning: Not fetching submodules of %s, they are nested more than %d levels deep", repoDir, maxSubmoduleDepth)
+		return nil
+	}
+
+	// Submodules may point anywhere, so they are held to the same rules as the repository.
+	// Relative URLs such as ../lib.git resolve against the parent repository.
+	for _, module := range modules {
+		if strings.HasPrefix(module.url, "./") || strings.HasPrefix(module.url, "../") {
+			continue
+		}
+		if err := validateRepoURL(module.url); err != nil {
+			return fmt.Errorf("%w: %s has a URL that is not allowed: %v", errInvalidSubmodule, module.name, err)
+		}
+	}
+
+	log.Printf("Fetching %s in %s", pluralize(len(modules), "submodule", "submodules"), repoDir)
+	output, err := runGit(ctx, auth, "-C", repoDir, "-c", "protocol.file.allow=never", "submodule", "update", "--init")
+	if err != nil {
+		return fmt.Errorf("git submodule update failed: %v - %s", err, output)
+	}
+
+	for _, module := range modules {
+		if err := updateSubmodules(ctx, auth, filepath.Join(repoDir, filepath.FromSlash(module.path)), level+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readGitmodules returns the submodules listed in the .gitmodules file of a repository
+func readGitmodules(ctx context.Context, auth *gitAuth, repoDir string) ([]submodule, error) {
+	if _, err := os.Stat(filepath.Join(repoDir, ".gitmodules")); err != nil {
+		return nil, nil
+	}
+
+	output, err := runGit(ctx, auth, "-C", repoDir, "config", "--file", ".gitmodules", "--get-regexp", `^submodule\..*\.(path|url)$`)
+	if err != nil {
+		// git config exits with 1 when nothing matches
+		if strings.TrimSpace(output) == "" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read .gitmodules: %v - %s", err, output)
+	}
+
+	// Each line is "submodule.<name>.<key> <value>", names may contain dots themselves
+	byName := make(map[string]*submodule)
+	var modules []*submodule
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		dot := strings.LastIndex(key, ".")
+		name, field := strings.TrimPrefix(key[:dot], "submodule."), key[dot+1:]
+
+		module, ok := byName[name]
+		if !ok {
+			module = &submodule{name: name}
+			byName[name] = module
+			modules = append(modules, module)
+		}
+		if field == "path" {
+			module.path = value
+		} else {
+			module.url = value
+		}
+	}
+
+	result := make([]submodule, 0, len(modules))
+	for _, module := range modules {
+		clean := path.Clean(module.path)
+		if module.path == "" || module.url == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return nil, fmt.Errorf("%w: %s has an invalid path or URL", errInvalidSubmodule, module.name)
+		}
+		result = append(result, *module)
+	}
+	return result, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const topTokenFiles = 10 // Number of files listed in the token summary
+
+// estimateTokens approximates the number of cl100k_base tokens in a text.
+// It splits the text the way the cl100k_base pre-tokenizer does (letter runs with an
+// optional leading character, digit groups of up to three, punctuation runs and
+// whitespace) and estimates the number of BPE tokens for each piece. The result is
+// an estimate meant for budgeting, not an exact count.
+func estimateTokens(text string) int {
+	runes := []rune(text)
+	tokens := 0
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case r == '\'' && contractionLength(runes[i+1:]) > 0:
+			// Contractions such as 's, 't, 're are single tokens
+			i += contractionLength(runes[i+1:]) + 1
+			tokens++
+
+		case startsLetterRun(runes, i):
+			// A letter run, optionally preceded by a single space or symbol
+			if !unicode.IsLetter(r) {
+				i++
+			}
+			ascii, other := 0, 0
+			for ; i < len(runes) && unicode.IsLetter(runes[i]); i++ {
+				if runes[i] <= unicode.MaxASCII {
+					ascii++
+				} else {
+					other++
+				}
+			}
+			// Common words are a single token and long identifiers split every few
+			// characters, while non-ASCII letters take roughly a token each
+			tokens += ceilDiv(ascii, 6) + other
+
+		case unicode.IsNumber(r):
+			// Digits are grouped in runs of at most three
+			n := 0
+			for ; i < len(runes) && unicode.IsNumber(runes[i]); i++ {
+				n++
+			}
+			tokens += ceilDiv(n, 3)
+
+		case unicode.IsSpace(r):
+			// Whitespace runs, including indentation and blank lines, encode compactly
+			for i < len(runes) && unicode.IsSpace(runes[i]) {
+				i++
+			}
+			tokens++
+
+		default:
+			// Punctuation and symbols merge into short multi-character tokens
+			n := 0
+			for ; i < len(runes) && isSymbol(runes[i]); i++ {
+				n++
+			}
+			tokens += ceilDiv(n, 2)
+		}
+	}
+
+	return tokens
+}
+
+// startsLetterRun reports whether a letter run, optionally with one leading space or
+// symbol, starts at position i
+func startsLetterRun(runes []rune, i int) bool {
+	if unicode.IsLetter(runes[i]) {
+		return true
+	}
+	if runes[i] == '\r' || runes[i] == '\n' || unicode.IsNumber(runes[i]) {
+		return false
+	}
+	return i+1 < len(runes) && unicode.IsLetter(runes[i+1])
+}
+
+// isSymbol reports whether a rune is neither a letter, a number nor whitespace
+func isSymbol(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsSpace(r)
+}
+
+// contractionLength returns the length of the contraction suffix at the start of rest, or 0
+func contractionLength(rest []rune) int {
+	for _, suffix := range []string{"re", "ve", "ll", "s", "t", "m", "d"} {
+		if len(rest) < len(suffix) {
+			continue
+		}
+		if strings.EqualFold(string(rest[:len(suffix)]), suffix) &&
+			(len(rest) == len(suffix) || !unicode.IsLetter(rest[len(suffix)])) {
+			return len(suffix)
+		}
+	}
+	return 0
+}
+
+// ceilDiv divides a by b, rounding up
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+// generateTokenSummary creates a markdown footer with the total token estimate and the
+// files that contribute the most tokens
+func generateTokenSummary(total int, fileTokens map[string]int) string {
+	var builder strings.Builder
+
+	builder.WriteString("---\n\n## Token Summary\n\n")
+	builder.WriteString(fmt.Sprintf("Estimated total tokens (cl100k_base): %d\n\n", total))
+
+	paths := make([]string, 0, len(fileTokens))
+	for path := range fileTokens {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if fileTokens[paths[i]] != fileTokens[paths[j]] {
+			return fileTokens[paths[i]] > fileTokens[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+	if len(paths) > topTokenFiles {
+		paths = paths[:topTokenFiles]
+	}
+
+	if len(paths) > 0 {
+		builder.WriteString("| File | Tokens |\n|------|--------|\n")
+		for _, path := range paths {
+			builder.WriteString(fmt.Sprintf("| %s | %d |\n", path, fileTokens[path]))
+		}
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
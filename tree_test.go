@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// update rewrites the golden files: go test -run Golden -update. The directory tree golden
+// file is written from the output of the 'tree' command, which the custom tree must match.
+var update = flag.Bool("update", false, "update golden files")
+
+// treeFixture is the repository the directory tree golden file is generated from
+var treeFixture = map[string]string{
+	".gitignore":                   "*.log\nbuild/\n!keep.log\n",
+	".gitattributes":               "* text=auto\n",
+	".github/workflows/ci.yml":     "on: push\n",
+	".env.example":                 "PORT=8080\n",
+	"README.md":                    "# Project\n",
+	"go.mod":                       "module example.com/project\n",
+	"cmd/server/main.go":           "package main\n",
+	"cmd/server/debug.log":         "ignored\n",
+	"internal/api/handler.go":      "package api\n",
+	"internal/api/handler_test.go": "package api\n",
+	"internal/store/store.go":      "package store\n",
+	"internal/store/keep.log":      "kept by a negation\n",
+	"build/output.bin":             "ignored directory\n",
+	"docs/guide.md":                "# Guide\n",
+	"docs/images/diagram.svg":      "<svg/>\n",
+	"empty/.gitkeep":               "",
+}
+
+// newTreeFixture writes treeFixture with a .git directory and returns its root
+func newTreeFixture(t *testing.T) string {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "project")
+	writeTestFiles(t, root, treeFixture)
+	writeTestFiles(t, root, map[string]string{".git/HEAD": "ref: refs/heads/main\n"})
+	return root
+}
+
+// runTree runs the 'tree' command the way generateDirectoryTree does, from the parent of
+// root so the output starts with its name like the custom tree, and in the C locale
+func runTree(t *testing.T, root string) string {
+	t.Helper()
+	if _, err := exec.LookPath("tree"); err != nil {
+		t.Skip("tree is not installed")
+	}
+	cmd := exec.Command("tree", "-a", "-I", ".git", "--gitignore", filepath.Base(root))
+	cmd.Dir = filepath.Dir(root)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("tree failed: %v", err)
+	}
+	return string(output)
+}
+
+func TestGenerateCustomDirectoryTreeGolden(t *testing.T) {
+	root := newTreeFixture(t)
+
+	golden := filepath.Join("testdata", "directory_tree.golden")
+	if *update {
+		if err := os.WriteFile(golden, []byte(runTree(t, root)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := generateCustomDirectoryTree(context.Background(), root)
+	if err != nil {
+		t.Fatalf("generateCustomDirectoryTree failed: %v", err)
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("directory tree does not match %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+	}
+}
+
+func TestGenerateCustomDirectoryTreeMatchesTree(t *testing.T) {
+	root := newTreeFixture(t)
+	want := runTree(t, root)
+
+	got, err := generateCustomDirectoryTree(context.Background(), root)
+	if err != nil {
+		t.Fatalf("generateCustomDirectoryTree failed: %v", err)
+	}
+	if got != want {
+		t.Errorf("custom directory tree differs from tree\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGenerateCustomDirectoryTreeCancelled(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{"a/b.txt": "b\n"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := generateCustomDirectoryTree(ctx, root); err == nil {
+		t.Error("cancelled tree generation returned no error")
+	}
+}
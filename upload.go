@@ -0,0 +1,369 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	maxUploadSize      = 512 * 1024 * 1024 // Maximum size of an uploaded archive
+	uploadMemoryLimit  = 32 * 1024 * 1024  // Part of a multipart upload kept in memory
+	uploadArchiveField = "file"            // Multipart field with the archive
+	uploadRequestField = "request"         // Optional multipart field with request options as JSON
+)
+
+// allowLocalPaths reports whether requests may analyze directories on the server, from the
+// ALLOW_LOCAL_PATHS env var
+func allowLocalPaths() bool {
+	return os.Getenv("ALLOW_LOCAL_PATHS") == "true"
+}
+
+// validateLocalPath checks a request for a directory on the server. Git options do not
+// apply to local directories, so they are rejected.
+func validateLocalPath(req *RepoRequest) error {
+	if !allowLocalPaths() {
+		return &requestError{http.StatusForbidden, "Local paths are not allowed"}
+	}
+	if req.RepoURL != "" {
+		return &requestError{http.StatusBadRequest, "Only one of repo_url or local_path may be specified"}
+	}
+	if err := rejectGitOptions(req, "local_path"); err != nil {
+		return err
+	}
+
+	if !filepath.IsAbs(req.LocalPath) {
+		return &requestError{http.StatusBadRequest, "Invalid local_path: path must be absolute"}
+	}
+	info, err := os.Stat(req.LocalPath)
+	if err != nil || !info.IsDir() {
+		return &requestError{http.StatusBadRequest, fmt.Sprintf("Invalid local_path: %q is not a directory", req.LocalPath)}
+	}
+	return nil
+}
+
+// rejectGitOptions returns an error if a request that is not cloned sets options that only
+// apply to git repositories
+func rejectGitOptions(req *RepoRequest, source string) error {
+	if req.Branch != "" || req.Tag != "" || req.Commit != "" || req.Depth != nil || req.Auth != nil || req.IsPrivate || req.Submodules || req.DiffBase != "" {
+		return &requestError{http.StatusBadRequest, "branch, tag, commit, depth, auth, is_private, submodules and diff_base cannot be used with " + source}
+	}
+	return nil
+}
+
+// handleAnalyzeUpload analyzes an uploaded .zip or .tar.gz archive. Request options such as
+// dirs can be passed as JSON in the request field of the multipart form.
+func handleAnalyzeUpload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(uploadMemoryLimit); err != nil {
+		log.Printf("Error parsing upload: %v", err)
+		writeJSONError(w, http.StatusBadRequest, "Invalid upload: "+err.Error())
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile(uploadArchiveField)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid upload: missing "+uploadArchiveField+" field")
+		return
+	}
+	defer file.Close()
+
+	var req RepoRequest
+	if options := r.FormValue(uploadRequestField); options != "" {
+		if err := json.Unmarshal([]byte(options), &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request field: "+err.Error())
+			return
+		}
+	}
+	if err := validateUpload(&req); err != nil {
+		log.Printf("Invalid request: %v", err)
+		writeJSONError(w, errorStatus(err), err.Error())
+		return
+	}
+	req.sourceName = archiveName(header.Filename)
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "markdown" // Default to markdown
+	}
+	if r.URL.Query().Get("count_tokens") == "true" {
+		req.CountTokens = true
+	}
+	if r.URL.Query().Get("dry_run") == "true" {
+		req.DryRun = true
+	}
+	req.trusted = isTrustedCaller(r)
+	req.highlight = r.URL.Query().Get("highlight") == "true"
+
+	release, ok := acquireAnalysisSlot(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(r.Context(), analyzeTimeout())
+	defer cancel()
+
+	log.Printf("Analyzing uploaded archive %s (%d bytes)", header.Filename, header.Size)
+	resp, err := analyzeUpload(ctx, &req, file, header.Filename, header.Size)
+	metrics.recordAnalysis(format, statsOf(resp), err)
+	if err != nil {
+		log.Printf("Analysis failed: %v", err)
+		writeJSONError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	writeResponse(w, &req, resp, format)
+	log.Printf("Response sent successfully with %d files", len(resp.Contents))
+}
+
+// validateUpload checks the options of an upload, which has no repository to clone
+func validateUpload(req *RepoRequest) error {
+	if req.RepoURL != "" || req.LocalPath != "" {
+		return &requestError{http.StatusBadRequest, "repo_url and local_path cannot be used with uploads"}
+	}
+	if err := rejectGitOptions(req, "uploads"); err != nil {
+		return err
+	}
+	if err := validateRefs(req); err != nil {
+		return &requestError{http.StatusBadRequest, "Invalid request: " + err.Error()}
+	}
+	if err := validateLanguageOverrides(req.LanguageOverrides); err != nil {
+		return &requestError{http.StatusBadRequest, "Invalid language_overrides: " + err.Error()}
+	}
+	if err := validateContentFilters(req); err != nil {
+		return &requestError{http.StatusBadRequest, "Invalid request: " + err.Error()}
+	}
+	if err := validateDirs(req.Dirs); err != nil {
+		return &requestError{http.StatusBadRequest, "Invalid dirs: " + err.Error()}
+	}
+	return nil
+}
+
+// statsOf returns the statistics of a response that may be nil
+func statsOf(resp *RepoResponse) *RepoStats {
+	if resp == nil {
+		return nil
+	}
+	return resp.Stats
+}
+
+// archiveName returns the name of an uploaded archive without its extension
+func archiveName(filename string) string {
+	name := filepath.Base(filename)
+	for _, extension := range []string{".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(strings.ToLower(name), extension) {
+			return name[:len(name)-len(extension)]
+		}
+	}
+	return name
+}
+
+// analyzeUpload extracts an uploaded archive into a new temporary directory and analyzes it
+func analyzeUpload(ctx context.Context, req *RepoRequest, archive io.ReaderAt, filename string, size int64) (*RepoResponse, error) {
+	repoID, repoDir, err := createRepoDir(req)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupRepo(repoDir) // Clean up after processing
+
+	// The size limit of repositories also bounds what an archive may expand to
+	limitKB := repoSizeLimitKB(req)
+	lower := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		err = extractZip(archive, size, repoDir, limitKB*1024)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		err = extractTarGz(io.NewSectionReader(archive, 0, size), repoDir, limitKB*1024)
+	default:
+		return nil, &requestError{http.StatusBadRequest, "Unsupported archive: expected a .zip, .tar.gz or .tgz file"}
+	}
+	if errors.Is(err, errRepoTooLarge) {
+		return nil, &requestError{http.StatusRequestEntityTooLarge, "Rejected archive: " + err.Error()}
+	}
+	if err != nil {
+		return nil, &requestError{http.StatusBadRequest, "Invalid archive: " + err.Error()}
+	}
+
+	log.Printf("Analyzing repository...")
+	resp, err := analyzeRepo(ctx, archiveRoot(repoDir), req)
+	if err != nil {
+		log.Printf("Failed to analyze repository: %v", err)
+		if ctx.Err() != nil {
+			return nil, contextError(ctx)
+		}
+		return nil, &requestError{http.StatusInternalServerError, "Failed to analyze repository: " + err.Error()}
+	}
+
+	resp.RepoID = repoID
+	return resp, nil
+}
+
+// archiveRoot returns the single top-level directory of an extracted archive, as created
+// by GitHub downloads and most tarballs, or dir itself otherwise
+func archiveRoot(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		return dir
+	}
+	return filepath.Join(dir, entries[0].Name())
+}
+
+// archiveEntryPath returns where an archive entry is extracted to inside dir. Absolute
+// paths and paths that leave dir through ".." are rejected.
+func archiveEntryPath(dir, name string) (string, error) {
+	clean := path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	if strings.HasPrefix(clean, "/") || clean == ".." || strings.HasPrefix(clean, "../") || filepath.VolumeName(clean) != "" {
+		return "", fmt.Errorf("unsafe path %q", name)
+	}
+
+	target := filepath.Join(dir, filepath.FromSlash(clean))
+	if target != dir && !strings.HasPrefix(target, dir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("unsafe path %q", name)
+	}
+	return target, nil
+}
+
+// archiveWriter writes extracted files while enforcing a limit on their total size
+type archiveWriter struct {
+	dir       string
+	limit     int64
+	remaining int64 // Bytes that may still be written, negative for no limit
+}
+
+// newArchiveWriter creates a writer for dir that extracts at most limit bytes, where zero
+// means no limit
+func newArchiveWriter(dir string, limit int64) (*archiveWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+	return &archiveWriter{dir: dir, limit: limit, remaining: limit}, nil
+}
+
+// mkdir creates a directory entry
+func (a *archiveWriter) mkdir(name string) error {
+	target, err := archiveEntryPath(a.dir, name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(target, 0755)
+}
+
+// writeFile creates a regular file entry with the contents of r
+func (a *archiveWriter) writeFile(name string, r io.Reader) error {
+	target, err := archiveEntryPath(a.dir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if a.remaining < 0 {
+		_, err = io.Copy(out, r)
+		return err
+	}
+
+	// Copy one byte more than allowed to detect archives that expand beyond the limit
+	written, err := io.CopyN(out, r, a.remaining+1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if written > a.remaining {
+		return fmt.Errorf("%w: extracted contents exceed the limit of %d KB", errRepoTooLarge, a.limit/1024)
+	}
+	a.remaining -= written
+	return nil
+}
+
+// extractZip extracts a zip archive into dir. Symbolic links and other special entries
+// are skipped, so nothing outside dir can be reached through them.
+func extractZip(archive io.ReaderAt, size int64, dir string, limit int64) error {
+	reader, err := zip.NewReader(archive, size)
+	if err != nil {
+		return err
+	}
+	out, err := newArchiveWriter(dir, limit)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range reader.File {
+		mode := entry.Mode()
+		switch {
+		case mode.IsDir():
+			err = out.mkdir(entry.Name)
+		case mode.IsRegular():
+			var content io.ReadCloser
+			if content, err = entry.Open(); err == nil {
+				err = out.writeFile(entry.Name, content)
+				content.Close()
+			}
+		default:
+			log.Printf("Skipping archive entry %s with mode %v", entry.Name, mode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractTarGz extracts a gzip compressed tar archive into dir. Like extractZip, only
+// directories and regular files are extracted.
+func extractTarGz(archive io.Reader, dir string, limit int64) error {
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	out, err := newArchiveWriter(dir, limit)
+	if err != nil {
+		return err
+	}
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = out.mkdir(header.Name)
+		case tar.TypeReg:
+			err = out.writeFile(header.Name, reader)
+		default:
+			log.Printf("Skipping archive entry %s of type %c", header.Name, header.Typeflag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
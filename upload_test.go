@@ -0,0 +1,79 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newUploadRequest creates a multipart upload of a zip archive with files
+func newUploadRequest(t *testing.T, target string, files map[string]string) *http.Request {
+	t.Helper()
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile(uploadArchiveField, "project.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(archive.Bytes())
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, target, &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestHandleAnalyzeUploadRepoID(t *testing.T) {
+	chdirTemp(t)
+	files := map[string]string{"project/main.go": "package main\n", "project/README.md": "# Project\n"}
+
+	w := httptest.NewRecorder()
+	handleAnalyzeUpload(w, newUploadRequest(t, "/analyze/upload?format=json", files))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var resp RepoResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.RepoID == "" {
+		t.Fatal("response has no repo_id")
+	}
+	if got := w.Header().Get(repoIDHeader); got != resp.RepoID {
+		t.Errorf("%s header = %q, want the repo_id %q", repoIDHeader, got, resp.RepoID)
+	}
+	if _, ok := resp.Contents["main.go"]; !ok {
+		t.Errorf("contents are %v, want the files below the archive root", resp.Contents)
+	}
+
+	// Every upload is analyzed in a directory of its own
+	w = httptest.NewRecorder()
+	handleAnalyzeUpload(w, newUploadRequest(t, "/analyze/upload", files))
+	if got := w.Header().Get(repoIDHeader); got == "" || got == resp.RepoID {
+		t.Errorf("second upload got %s %q, want a new ID", repoIDHeader, got)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/markdown") {
+		t.Errorf("Content-Type = %q, want markdown by default", w.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	zipTreeName     = "TREE.txt"
+	zipAnalysisName = "ANALYSIS.md"
+)
+
+// setZipHeaders sets the headers of a zip download
+func setZipHeaders(w http.ResponseWriter, req *RepoRequest) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-analysis.zip", req.repoName()))
+}
+
+// writeZipEntry adds a compressed file to a zip archive
+func writeZipEntry(zw *zip.Writer, name, content string) error {
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(entry, content)
+	return err
+}
+
+// streamZip clones a repository and streams a zip archive with every collected file at its
+// relative path, plus the directory tree and the markdown analysis. Files are added as they
+// are read; the analysis is spooled to a temporary file and added last. Errors before the
+// response is started are returned. It returns the statistics of the files written.
+func streamZip(ctx context.Context, w http.ResponseWriter, req *RepoRequest) (*RepoStats, error) {
+	repoID, repoDir, tree, candidates, skipped, err := prepareStream(ctx, req)
+	defer cleanupRepo(repoDir) // Clean up after processing
+	if err != nil {
+		return nil, err
+	}
+
+	// zip entries can only be written one at a time, so the analysis is built on the side
+	analysis, err := os.CreateTemp("", "gitdump-analysis-*.md")
+	if err != nil {
+		return nil, &requestError{http.StatusInternalServerError, "Failed to create analysis file: " + err.Error()}
+	}
+	defer os.Remove(analysis.Name())
+	defer analysis.Close()
+
+	setZipHeaders(w, req)
+	w.Header().Set(repoIDHeader, repoID)
+	zw := zip.NewWriter(w)
+
+	// The tree is only added along with the first file, so nothing is written if the
+	// analysis is cancelled before any file was read
+	treeWritten := false
+	var writeErr error
+	writeTree := func() {
+		if !treeWritten {
+			treeWritten = true
+			writeErr = writeZipEntry(zw, zipTreeName, tree)
+		}
+	}
+	stats, readSkipped, err := writeStreamedDocument(ctx, analysis, false, tree, candidates, req, func(file fileResult) {
+		writeTree()
+		if writeErr == nil {
+			writeErr = writeZipEntry(zw, filepath.ToSlash(file.relPath), file.content)
+		}
+	})
+	metrics.recordSkipped(append(skipped, readSkipped...))
+	if err != nil {
+		return stats, err
+	}
+	if ctx.Err() == nil {
+		writeTree() // Adds the tree when no file made it into the archive
+	}
+	if writeErr != nil {
+		log.Printf("Error: Failed to write zip archive: %v", writeErr)
+		return stats, nil
+	}
+	if ctx.Err() != nil {
+		// Leave the archive without its central directory, so clients see it is incomplete
+		log.Printf("Error: Streaming aborted after %d files: %v", stats.TotalFiles, ctx.Err())
+		return stats, nil
+	}
+
+	if _, err := analysis.Seek(0, io.SeekStart); err != nil {
+		log.Printf("Error: Failed to read analysis file: %v", err)
+		return stats, nil
+	}
+	entry, err := zw.CreateHeader(&zip.FileHeader{Name: zipAnalysisName, Method: zip.Deflate, Modified: time.Now()})
+	if err == nil {
+		_, err = io.Copy(entry, analysis)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		log.Printf("Error: Failed to write zip archive: %v", err)
+	}
+
+	if stats.TotalFiles == 0 {
+		log.Printf("Warning: No file contents were collected, %d files were skipped", len(skipped)+len(readSkipped))
+	}
+	return stats, nil
+}
+
+// writeZipResponse writes an already finished analysis as a zip archive
+func writeZipResponse(w http.ResponseWriter, req *RepoRequest, resp *RepoResponse) {
+	setZipHeaders(w, req)
+	zw := zip.NewWriter(w)
+
+	paths := make([]string, 0, len(resp.Contents))
+	for path := range resp.Contents {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	err := writeZipEntry(zw, zipTreeName, resp.Tree)
+	for _, path := range paths {
+		if err != nil {
+			break
+		}
+		err = writeZipEntry(zw, filepath.ToSlash(strings.TrimPrefix(path, "/")), resp.Contents[path])
+	}
+	if err == nil {
+		err = writeZipEntry(zw, zipAnalysisName, resp.Markdown)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		log.Printf("Error: Failed to write zip archive: %v", err)
+	}
+}